@@ -0,0 +1,212 @@
+package minirpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// 服务端设置了 Authenticator 时，握手确认之后双方交换 flagAuth 帧完成认证，
+// 最后服务端发送 flagHandshake|flagAuth 帧表示认证通过，认证失败时发送带有 flagError 的错误帧，
+// 客户端据此得到明确的错误信息，而不是连接被直接关闭。
+
+// Principal 是通过认证的调用方。
+type Principal struct {
+	Name  string
+	Roles []string
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the authenticated caller of the request being handled.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// AuthConn 用于在认证时收发认证帧。
+type AuthConn interface {
+	Send(p []byte) error
+	Recv() ([]byte, error)
+}
+
+// Authenticator authenticates clients on the server side.
+// ctx 中带有 Peer，可以结合 TLS 客户端证书进行认证。
+type Authenticator interface {
+	Scheme() string
+	Authenticate(ctx context.Context, conn AuthConn) (*Principal, error)
+}
+
+// Credentials is the client side of an Authenticator with the same scheme.
+type Credentials interface {
+	Scheme() string
+	Respond(conn AuthConn) error
+}
+
+// authConn 在 frameCodec 上实现 AuthConn，收到错误帧时返回其中的错误信息。
+type authConn struct {
+	cc *frameCodec
+}
+
+func (c authConn) Send(p []byte) error {
+	return c.cc.writeFrame(flagAuth, 0, p)
+}
+
+func (c authConn) Recv() ([]byte, error) {
+	payload, err := c.cc.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if c.cc.frame.Flags&flagError != 0 {
+		return nil, errors.New(string(payload))
+	}
+	if c.cc.frame.Flags != flagAuth {
+		return nil, errors.New("rpc: expect an authentication frame")
+	}
+	return payload, nil
+}
+
+// authenticate 在服务端完成认证，返回带有 Principal 的连接上下文。
+func (server *Server) authenticate(ctx context.Context, cc *frameCodec) (context.Context, error) {
+	if server.Authenticator == nil {
+		return ctx, nil
+	}
+	p, err := server.Authenticator.Authenticate(ctx, authConn{cc})
+	if err == nil && p == nil {
+		err = errors.New("no principal")
+	}
+	if err != nil {
+		_ = cc.writeFrame(flagHandshake|flagAuth|flagError, 0, []byte("rpc server: authentication failed: "+err.Error()))
+		return nil, err
+	}
+	if err = cc.writeFrame(flagHandshake|flagAuth, 0, nil); err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+// clientAuthenticate 在客户端完成认证，并等待服务端的认证结果。
+func clientAuthenticate(cc *frameCodec, creds Credentials) error {
+	if creds == nil {
+		return errors.New("rpc client: server requires authentication but no credentials are configured")
+	}
+	if err := creds.Respond(authConn{cc}); err != nil {
+		return err
+	}
+	payload, err := cc.readFrame()
+	if err != nil {
+		return err
+	}
+	if cc.frame.Flags&flagError != 0 {
+		return errors.New(string(payload))
+	}
+	if cc.frame.Flags != flagHandshake|flagAuth {
+		return errors.New("rpc client: expect an authentication result frame")
+	}
+	return nil
+}
+
+// TokenAuthenticator 使用静态令牌认证客户端，客户端发送令牌，服务端查找对应的 Principal。
+type TokenAuthenticator struct {
+	tokens map[string]*Principal
+}
+
+// NewTokenAuthenticator returns an Authenticator that accepts the given tokens.
+func NewTokenAuthenticator(tokens map[string]*Principal) *TokenAuthenticator {
+	return &TokenAuthenticator{tokens: tokens}
+}
+
+func (a *TokenAuthenticator) Scheme() string { return "token" }
+
+func (a *TokenAuthenticator) Authenticate(ctx context.Context, conn AuthConn) (*Principal, error) {
+	token, err := conn.Recv()
+	if err != nil {
+		return nil, err
+	}
+	// 逐个进行常量时间比较，避免通过响应时间猜测令牌
+	var found *Principal
+	for t, p := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), token) == 1 {
+			found = p
+		}
+	}
+	if found == nil {
+		return nil, errors.New("invalid token")
+	}
+	return found, nil
+}
+
+// TokenCredentials 是 TokenAuthenticator 对应的客户端凭证。
+type TokenCredentials string
+
+func (t TokenCredentials) Scheme() string { return "token" }
+
+func (t TokenCredentials) Respond(conn AuthConn) error {
+	return conn.Send([]byte(t))
+}
+
+// HMACAuthenticator 使用共享密钥的挑战应答认证客户端：
+// 服务端发送随机的 nonce，客户端回复 "id:hex(HMAC-SHA256(key, nonce))"，密钥本身不会在连接上传输。
+type HMACAuthenticator struct {
+	keys map[string][]byte
+}
+
+// NewHMACAuthenticator returns an Authenticator that accepts clients holding one of keys.
+// keys 的键是客户端的 id，也是认证通过后 Principal 的 Name。
+func NewHMACAuthenticator(keys map[string][]byte) *HMACAuthenticator {
+	return &HMACAuthenticator{keys: keys}
+}
+
+func (a *HMACAuthenticator) Scheme() string { return "hmac-sha256" }
+
+func (a *HMACAuthenticator) Authenticate(ctx context.Context, conn AuthConn) (*Principal, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	if err := conn.Send(nonce); err != nil {
+		return nil, err
+	}
+	resp, err := conn.Recv()
+	if err != nil {
+		return nil, err
+	}
+	i := strings.LastIndexByte(string(resp), ':')
+	if i < 0 {
+		return nil, errors.New("malformed response")
+	}
+	id := string(resp[:i])
+	key, ok := a.keys[id]
+	mac, err := hex.DecodeString(string(resp[i+1:]))
+	if !ok || err != nil || !hmac.Equal(mac, signNonce(key, nonce)) {
+		return nil, errors.New("invalid signature")
+	}
+	return &Principal{Name: id}, nil
+}
+
+// HMACCredentials 是 HMACAuthenticator 对应的客户端凭证。
+type HMACCredentials struct {
+	ID  string
+	Key []byte
+}
+
+func (c *HMACCredentials) Scheme() string { return "hmac-sha256" }
+
+func (c *HMACCredentials) Respond(conn AuthConn) error {
+	nonce, err := conn.Recv()
+	if err != nil {
+		return err
+	}
+	return conn.Send([]byte(c.ID + ":" + hex.EncodeToString(signNonce(c.Key, nonce))))
+}
+
+func signNonce(key, nonce []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(nonce)
+	return h.Sum(nil)
+}
@@ -0,0 +1,83 @@
+package minirpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+type Me int
+
+func (m Me) Name(ctx context.Context, args int, reply *string) error {
+	p, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return errors.New("no principal")
+	}
+	*reply = p.Name + "/" + strings.Join(p.Roles, ",")
+	return nil
+}
+
+func startAuthServer(auth Authenticator) (*Server, string) {
+	server := NewServer()
+	server.Authenticator = auth
+	var m Me
+	_ = server.Register(&m)
+	l, err := net.Listen("tcp", ":0")
+	_assert(err == nil, "failed to listen: %v", err)
+	go server.Accept(l)
+	return server, l.Addr().String()
+}
+
+func callMe(addr string, creds Credentials) (string, error) {
+	client, err := Dial("tcp", addr, &Option{Credentials: creds})
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = client.Close() }()
+	var name string
+	err = client.Call(context.Background(), "Me.Name", 0, &name)
+	return name, err
+}
+
+// 通过令牌认证的调用方会出现在每个请求的上下文中，认证失败时客户端得到明确的错误。
+func TestTokenAuthenticator(t *testing.T) {
+	t.Parallel()
+	server, addr := startAuthServer(NewTokenAuthenticator(map[string]*Principal{
+		"s3cret": {Name: "alice", Roles: []string{"admin", "dev"}},
+	}))
+	defer func() { _ = server.Close() }()
+
+	name, err := callMe(addr, TokenCredentials("s3cret"))
+	_assert(err == nil && name == "alice/admin,dev", "expect alice/admin,dev, got %q (%v)", name, err)
+	_, err = callMe(addr, TokenCredentials("wrong"))
+	_assert(err != nil && strings.Contains(err.Error(), "authentication failed: invalid token"), "unexpected error: %v", err)
+	_, err = callMe(addr, nil)
+	_assert(err != nil && strings.Contains(err.Error(), "authentication required"), "unexpected error: %v", err)
+	_, err = callMe(addr, &HMACCredentials{ID: "alice", Key: []byte("s3cret")})
+	_assert(err != nil && strings.Contains(err.Error(), "authentication required"), "unexpected error: %v", err)
+}
+
+// HMAC 挑战应答认证，错误的密钥无法通过认证。
+func TestHMACAuthenticator(t *testing.T) {
+	t.Parallel()
+	server, addr := startAuthServer(NewHMACAuthenticator(map[string][]byte{"svc-a": []byte("key-a")}))
+	defer func() { _ = server.Close() }()
+
+	name, err := callMe(addr, &HMACCredentials{ID: "svc-a", Key: []byte("key-a")})
+	_assert(err == nil && name == "svc-a/", "expect svc-a/, got %q (%v)", name, err)
+	_, err = callMe(addr, &HMACCredentials{ID: "svc-a", Key: []byte("key-b")})
+	_assert(err != nil && strings.Contains(err.Error(), "authentication failed: invalid signature"), "unexpected error: %v", err)
+	_, err = callMe(addr, &HMACCredentials{ID: "svc-b", Key: []byte("key-a")})
+	_assert(err != nil && strings.Contains(err.Error(), "authentication failed"), "unexpected error: %v", err)
+}
+
+// 服务端不要求认证时，客户端配置的凭证被忽略。
+func TestAuth_notRequired(t *testing.T) {
+	t.Parallel()
+	server, addr := startAuthServer(nil)
+	defer func() { _ = server.Close() }()
+	_, err := callMe(addr, TokenCredentials("s3cret"))
+	_assert(err != nil && strings.Contains(err.Error(), "no principal"), "unexpected error: %v", err)
+}
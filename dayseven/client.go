@@ -28,6 +28,10 @@ type Call struct {
 	ServiceMethod string // format "<service>.<method>"
 	Args interface{} // arguments to the function
 	Reply interface{} // reply from the function
+	Metadata Metadata // metadata sent with the request
+	ReplyMetadata Metadata // metadata sent back with the response
+	deadline time.Time // 调用方上下文的截止时间，随请求发送给服务端
+	stream *ClientStream // 流式调用时接收服务端发送的消息
 	Error error
 	//Go语言中的通道（channel）是一种特殊的类型。在任何时候，
 	//同时只能有一个 goroutine 访问通道进行发送和获取数据。
@@ -46,13 +50,18 @@ type newClientFunc func(conn net.Conn,opt *Option)(client *Client,err error)
 
 
 func (call *Call) done()  {
+	if call.stream != nil {
+		//流已经结束，不能再发送消息
+		call.stream.out.close(io.EOF)
+	}
 	call.Done <- call
 }
 
 
 type Client struct {
-	cc codec.Codec 	//cc 是消息的编解码器，和服务端类似，用来序列化将要发送出去的请求，以及反序列化接收到的响应。
+	cc *frameCodec 	//cc 是消息的编解码器，和服务端类似，用来序列化将要发送出去的请求，以及反序列化接收到的响应。
 	opt *Option
+	invoker Invoker //经过 opt.Interceptors 包装的调用
 	//sync包和channel机制来解决并发机制中不同goroutine之间的同步和通信
 	//sync.Mutex是一个互斥锁，可以由不同的goroutine加锁和解锁。
 	sending sync.Mutex //sending 是一个互斥锁，和服务端类似，为了保证请求的有序发送，即防止出现多个请求报文混淆。
@@ -62,6 +71,11 @@ type Client struct {
 	pending map[uint64]*Call //pending 存储未处理完的请求，键是编号，值是 Call 实例。
 	closing bool // user has called Close
 	shutdown bool // server has told us to stop
+	draining bool // server is shutting down, no new calls but pending calls will be answered
+	handlers map[string]PushHandler // Handle 注册的推送处理函数
+	pushes chan *Push // 收到的推送，由单独的协程依次交给处理函数
+	droppedPushes uint64 // 队列已满时丢弃的推送数
+	done chan struct{} // receive 退出、连接不再可用时关闭
 }
 
 
@@ -69,6 +83,10 @@ var _ io.Closer = (*Client)(nil)
 
 var ErrShutdown = errors.New("connection is shut down")
 
+// ErrUnavailable is returned for calls that failed because the connection was lost
+// or could not be established. Such calls can be retried, possibly on a new connection.
+var ErrUnavailable = errors.New("rpc client: connection unavailable")
+
 
 func (client *Client) Close() error {
 	client.mu.Lock()
@@ -83,7 +101,14 @@ func (client *Client) Close() error {
 func (client *Client)IsAvailable() bool  {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.draining
+}
+// NumPending returns the number of calls waiting for a response.
+// 连接池据此选择最空闲的连接。
+func (client *Client) NumPending() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.pending)
 }
 //将参数 call 添加到 client.pending 中，并更新 client.seq。
 func (client *Client) registerCall(call *Call)(uint64,error)  {
@@ -92,6 +117,9 @@ func (client *Client) registerCall(call *Call)(uint64,error)  {
 	if client.closing || client.shutdown {
 		return 0,ErrShutdown
 	}
+	if client.draining {
+		return 0,ErrServerShuttingDown
+	}
 	call.Seq = client.seq
 	client.pending[call.Seq] = call
 	client.seq++
@@ -107,12 +135,21 @@ func (client *Client) removeCall(seq uint64) *Call  {
 }
 //：服务端或客户端发生错误时调用，
 //将 shutdown 设置为 true，且将错误信息通知所有 pending 状态的 call。
-func (client Client) terminateCalls(err error)  {
+func (client *Client) terminateCalls(err error)  {
 	client.sending.Lock()
 	defer client.sending.Unlock()
 	client.mu.Lock()
 	defer client.mu.Unlock()
 	client.shutdown =true
+	//连接断开时，调用方无法知道请求是否已经被处理，包装为 ErrUnavailable 由调用方决定是否重试；
+	//即使服务端正在优雅关闭也是如此，它可能在处理完请求之前被强制关闭。
+	//只有服务端拒绝了的请求才会在响应中得到 ErrServerShuttingDown。
+	switch {
+	case client.closing:
+		err = ErrShutdown
+	default:
+		err = fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
 	for _,call :=range client.pending{
 		call.Error = err
 		call.done()
@@ -124,53 +161,167 @@ func (client *Client) receive()  {
 	for err==nil {
 		var h codec.Header
 		if err=client.cc.ReadHeader(&h);err!=nil {
-			break
+			if !errors.Is(err, errMalformedFrame) {
+				break
+			}
+			// 无法解码的帧已被跳过，只让对应的调用失败
+			if call:=client.removeCall(h.Seq);call!=nil {
+				call.Error = err
+				call.done()
+			}
+			err = nil
+			continue
+		}
+		if client.cc.frame.Flags&flagGoAway != 0 {
+			//服务端即将关闭，不再发起新的调用，已经发出的调用继续等待响应
+			client.mu.Lock()
+			client.draining = true
+			client.mu.Unlock()
+			continue
+		}
+		if client.cc.frame.Flags&flagPush != 0 {
+			//服务端推送的事件不占用 seq，不会和调用的响应混淆
+			client.queuePush(&Push{Event: h.ServiceMethod, body: client.cc.takeBody()})
+			continue
+		}
+		if client.cc.frame.Flags&flagWindow != 0 {
+			//服务端处理完了流上的一部分消息，可以继续发送
+			client.mu.Lock()
+			call:=client.pending[h.Seq]
+			client.mu.Unlock()
+			if call != nil && call.stream != nil {
+				call.stream.out.grant(windowIncrement(client.cc.ctrl))
+			}
+			continue
+		}
+		if client.cc.frame.Flags&(flagStream|flagEndStream) == flagStream {
+			//流中的消息交给对应的 ClientStream，由 Recv 解码，调用在结束帧到达时才完成
+			client.mu.Lock()
+			call:=client.pending[h.Seq]
+			client.mu.Unlock()
+			if call != nil && call.stream != nil {
+				if call.stream.in.push(client.cc.takeBody()) {
+					//服务端不遵守流控，取消请求让它停止发送，不在读取连接的协程中写入
+					go call.stream.abort(errWindowExceeded)
+				}
+			} else {
+				err = client.cc.ReadBody(nil)
+			}
+			continue
 		}
 		call:=client.removeCall(h.Seq)
+		if call != nil {
+			call.ReplyMetadata = h.Metadata
+		}
 		switch  {
 		case call==nil:
 		// it usually means that Write partially failed
 		// and call was already removed.
 		err = client.cc.ReadBody(nil)
+		case h.Error==ErrServerShuttingDown.Error():
+			call.Error = ErrServerShuttingDown
+			err = client.cc.ReadBody(nil)
+			call.done()
+		case strings.HasPrefix(h.Error,ErrPermissionDenied.Error()):
+			call.Error = fmt.Errorf("%w%s",ErrPermissionDenied,strings.TrimPrefix(h.Error,ErrPermissionDenied.Error()))
+			err = client.cc.ReadBody(nil)
+			call.done()
+		case strings.HasPrefix(h.Error,ErrHandleTimeout.Error()):
+			call.Error = fmt.Errorf("%w%s",ErrHandleTimeout,strings.TrimPrefix(h.Error,ErrHandleTimeout.Error()))
+			err = client.cc.ReadBody(nil)
+			call.done()
 		case h.Error!="":
-			call.Error = fmt.Errorf(h.Error)
-			err = client.cc.ReadHeader(nil)
+			call.Error = errors.New(h.Error)
+			err = client.cc.ReadBody(nil)
 			call.done()
 		default:
-			if err=client.cc.ReadBody(call.Reply);err != nil {
-				call.Error = errors.New("reading body" + err.Error())
+			// 消息体解码失败只影响这一次调用，帧已经完整读取，连接仍然可用
+			if e:=client.cc.ReadBody(call.Reply);e != nil {
+				call.Error = errors.New("reading body " + e.Error())
 			}
 			call.done()
 		}
 	}
 	client.terminateCalls(err)
+	close(client.pushes)
+	//连接已经不可用，关闭它以释放资源；用户之后调用 Close 时返回 ErrShutdown
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if !client.closing {
+		client.closing = true
+		_ = client.cc.Close()
+	}
+	close(client.done)
 }
-//创建 Client 实例时，首先需要完成一开始的协议交换，即发送 Option 信息给服务端。
+//创建 Client 实例时，首先需要完成一开始的协议交换，即发送握手帧（携带 Option）给服务端并等待确认。
 //协商好消息的编解码方式之后，再创建一个子协程调用 receive() 接收响应。
 
 func NewClient(conn net.Conn,opt *Option)(*Client,error)  {
-	f:=codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
+	f,ok:=codec.Lookup(opt.CodecType)
+	if !ok {
 		err:=fmt.Errorf("invalid codec type %s",opt.CodecType)
 		log.Println("rpc client:codec error :",err)
 		return nil, err
 	}
-	if err:=json.NewEncoder(conn).Encode(opt);err != nil {
+	cc := newFrameCodec(conn)
+	cc.setCodec(opt.CodecType, f)
+	if err:=clientHandshake(cc,opt);err != nil {
 		log.Println("rpc client:options error:",err)
 		_=conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn),opt),nil
+	return newClientCodec(cc,opt),nil
+}
+
+// clientHandshake 发送握手帧并等待服务端确认，服务端拒绝时返回它给出的错误信息。
+func clientHandshake(cc *frameCodec, opt *Option) error {
+	o := *opt
+	if opt.Credentials != nil {
+		o.AuthScheme = opt.Credentials.Scheme()
+	}
+	payload, err := json.Marshal(&o)
+	if err != nil {
+		return err
+	}
+	if err = cc.writeFrame(flagHandshake, 0, payload); err != nil {
+		return err
+	}
+	if payload, err = cc.readFrame(); err != nil {
+		return err
+	}
+	if cc.frame.Flags&flagError != 0 {
+		return errors.New(string(payload))
+	}
+	if cc.frame.Flags&flagHandshake == 0 {
+		return errors.New("rpc client: expect a handshake frame")
+	}
+	// 服务端确认的 Option 中带有最终使用的压缩算法
+	var accepted Option
+	if err = json.Unmarshal(payload, &accepted); err != nil {
+		return err
+	}
+	if accepted.AuthScheme != "" {
+		if err = clientAuthenticate(cc, opt.Credentials); err != nil {
+			return err
+		}
+	}
+	cc.setCompression(accepted.Compression, opt.CompressThreshold)
+	return nil
 }
 //协商好消息的编解码方式之后，再创建一个子协程调用 receive() 接收响应。
-func newClientCodec(cc codec.Codec, opt *Option) *Client {
+func newClientCodec(cc *frameCodec, opt *Option) *Client {
 	client:=&Client{
 		seq:1,
 		cc:cc,
 		opt:opt,
 		pending: make(map[uint64]*Call),
+		handlers: make(map[string]PushHandler),
+		pushes: make(chan *Push, pushQueueLen),
+		done: make(chan struct{}),
 	}
+	client.invoker = chainClientInterceptors(opt.Interceptors,client.invoke)
 	go client.receive()
+	go client.dispatchPushes()
 	return client
 }
 //为了简化用户调用，通过 ...*Option 将 Option 实现为可选参数。
@@ -182,12 +333,13 @@ func parseOptions(opts ...*Option)(*Option,error)  {
 	if len(opts)!=1 {
 		return nil,errors.New("number of options is more than 1")
 	}
-	opt :=opts[0]
+	//复制一份再补全默认值，同一个 Option 可能被多个协程同时用来建立连接
+	opt :=*opts[0]
 	opt.MagicNumber = DefaultOption.MagicNumber
 	if opt.CodecType=="" {
 		opt.CodecType =DefaultOption.CodecType
 	}
-	return opt,nil
+	return &opt,nil
 }
 
 // Dial connects to an RPC server at the specified network address
@@ -206,6 +358,11 @@ func dialTimeout(f newClientFunc,network,address string, opts ...*Option)(client
 	if err != nil {
 		return nil,err
 	}
+	return newClientTimeout(f,conn,opt)
+}
+
+//newClientTimeout 在已经建立的连接上完成握手，握手同样受 ConnectTimeout 限制，失败时关闭连接。
+func newClientTimeout(f newClientFunc,conn net.Conn,opt *Option)(client *Client, err error)  {
 	defer func() {
 		if err != nil {
 			_ = conn.Close()
@@ -222,7 +379,7 @@ func dialTimeout(f newClientFunc,network,address string, opts ...*Option)(client
 	}
 	select {
 	case <-time.After(opt.ConnectTimeout):
-		return nil,fmt.Errorf("rpc client:connect timeout: expect within %s",opt.ConnectTimeout)
+		return nil,fmt.Errorf("rpc client:connect timeout: expect within %s (%w)",opt.ConnectTimeout,ErrUnavailable)
 	case result:= <-ch:
 		return result.client,result.err
 	}
@@ -243,8 +400,24 @@ func (client *Client) send(call *Call)  {
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq=seq
 	client.header.Error=""
+	client.header.Metadata=call.Metadata
+	client.header.Timeout=0
+	if !call.deadline.IsZero() {
+		//已经过了截止时间的调用仍然发送，由调用方的 ctx.Done() 返回错误
+		client.header.Timeout = time.Until(call.deadline)
+		if client.header.Timeout <= 0 {
+			client.header.Timeout = time.Nanosecond
+		}
+	}
 
-	if err:=client.cc.Write(&client.header,call.Args);err!=nil {
+	flags := frameFlag(0)
+	if call.stream != nil {
+		flags = flagStream
+		if call.stream.closeSend {
+			flags |= flagEndStream
+		}
+	}
+	if err:=client.cc.writeMessage(flags,&client.header,call.Args);err!=nil {
 		call:=client.removeCall(seq)
 		if call != nil {
 			call.Error=err
@@ -253,11 +426,21 @@ func (client *Client) send(call *Call)  {
 	}
 }
 
+// cancelCall 发送取消帧，服务端收到后取消 seq 对应请求的上下文，并且不再发送响应。
+func (client *Client) cancelCall(seq uint64) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	if !client.IsAvailable() {
+		return
+	}
+	_ = client.cc.writeFrame(flagCancel, seq, nil)
+}
+
 // Go invokes the function asynchronously.
 // It returns the Call structure representing the invocation.
 //Go 和 Call 是客户端暴露给用户的两个 RPC 服务调用接口，Go 是一个异步接口，返回 call 实例。
 func (client *Client) Go(serviceMethod string,args,reply interface{},done chan *Call) *Call {
-	if done != nil {
+	if done == nil {
 		//make(chan int, 1) 是 buffered channel, 容量为 1。
 		//make(chan int) 是 unbuffered channel, send 之后 send 语句会阻塞执行,直到有人 receive 之后 send 解除阻塞，后面的语句接着执行。
 		done = make(chan *Call,10)
@@ -274,17 +457,38 @@ func (client *Client) Go(serviceMethod string,args,reply interface{},done chan *
 	return call
 }
 //Call 是对 Go 的封装，阻塞 call.Done，等待响应返回，是一个同步接口。
+//调用会依次经过 Option.Interceptors 中的拦截器。
 func (client *Client) Call(ctx context.Context,serviceMethod string,args,reply interface{}) error  {
+	return client.invoker(ctx,serviceMethod,args,reply)
+}
+
+//invoke 是拦截器链最内层的 Invoker，完成实际的调用。
+func (client *Client) invoke(ctx context.Context,serviceMethod string,args,reply interface{}) error  {
 	//Client.Call 的超时处理机制，使用 context 包实现，控制权交给用户，控制更为灵活。
-	call :=client.Go(serviceMethod,args,reply,make(chan *Call,1))
+	//通过 WithMetadata 放入 ctx 的元数据随请求一起发送。
+	call :=&Call{
+		ServiceMethod: serviceMethod,
+		Args: args,
+		Reply: reply,
+		Metadata: outgoingMetadata(ctx),
+		Done: make(chan *Call,1),
+	}
+	call.deadline,_ = ctx.Deadline()
+	client.send(call)
 	select {
 		case <-ctx.Done():
-			client.removeCall(call.Seq)
+			//通知服务端取消还在处理中的请求，服务端不会再发送响应
+			if client.removeCall(call.Seq)!=nil {
+				client.cancelCall(call.Seq)
+			}
 			return errors.New("rpc client: call failed:"+ctx.Err().Error())
 		case call :=<-call.Done:
+			//服务端返回的响应元数据写入 WithReplyMetadata 传入的 Metadata
+			if md, ok := ctx.Value(replyHeaderKey{}).(*Metadata); ok {
+				*md = call.ReplyMetadata
+			}
 			return call.Error
 	}
-	return call.Error
 }
 
 func NewHTTPClient(conn net.Conn,opt *Option)(*Client,error)  {
@@ -315,6 +519,9 @@ func XDial(rpcAddr string,opts ...*Option)(*Client,error)  {
 	switch protocol {
 	case "http":
 		return DialHTTP("tcp",addr,opts...)
+	case "tls":
+		//证书配置来自 Option.TLSConfig
+		return DialTLS("tcp",addr,nil,opts...)
 	default:
 		//tcp,unix or other transport protocol
 		return Dial(protocol,addr,opts...)
@@ -2,6 +2,8 @@ package minirpc
 
 import (
 	"context"
+	"fmt"
+	"minirpc/codec"
 	"net"
 	"os"
 	"runtime"
@@ -36,9 +38,69 @@ func (b Bar) Timeout(argv int,reply *int)error  {
 	return nil
 }
 
+// Record 用于测试结构体、切片、map 和指针等复杂类型的编解码。
+type Record struct {
+	ID    int
+	Name  string
+	Tags  []string
+	Attrs map[string]float64
+	Next  *Record
+}
+
+type Store int
+
+func (s Store) Records(n int, reply *[]Record) error {
+	for i := 0; i < n; i++ {
+		r := Record{ID: i, Name: fmt.Sprintf("record-%d", i), Tags: []string{"a", "b"}, Attrs: map[string]float64{"score": float64(i) / 2}}
+		if i > 0 {
+			r.Next = &Record{ID: i - 1}
+		}
+		*reply = append(*reply, r)
+	}
+	return nil
+}
+
+// CtxInfo 是 Ctx.Info 的回复，描述服务端看到的请求上下文。
+type CtxInfo struct {
+	RequestID   string
+	Peer        string
+	HasDeadline bool
+}
+
+func (c Ctx) Info(ctx context.Context, args int, reply *CtxInfo) error {
+	reply.RequestID = MetadataFromContext(ctx)["request-id"]
+	if p, ok := PeerFromContext(ctx); ok && p.Addr != nil {
+		reply.Peer = p.Addr.String()
+	}
+	_, reply.HasDeadline = ctx.Deadline()
+	return SetReplyMetadata(ctx, "request-id", reply.RequestID)
+}
+
+// ctxCanceled 记录 Ctx.Wait 是否观察到上下文被取消，键是 Ctx.Wait 的参数，每个测试使用不同的键。
+var ctxCanceled = map[int]chan error{1: make(chan error, 1), 2: make(chan error, 1)}
+
+func (c Ctx) Wait(ctx context.Context, args int, reply *int) error {
+	select {
+	case <-ctx.Done():
+		select {
+		case ctxCanceled[args] <- ctx.Err():
+		default:
+		}
+		return ctx.Err()
+	case <-time.After(time.Second * 5):
+		return nil
+	}
+}
+
 func startServer(addr chan string)  {
 	var b Bar
 	_ = Register(&b)
+	var foo Foo
+	_ = Register(&foo)
+	var store Store
+	_ = Register(&store)
+	var c Ctx
+	_ = Register(&c)
 	//	pck a free port
 	l,_:=net.Listen("tcp",":0")
 	addr <-l.Addr().String()
@@ -53,7 +115,8 @@ func TestClient_Call(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("client timeout ", func(t *testing.T) {
 		client,_ := Dial("tcp",addr)
-		ctx,_ := context.WithTimeout(context.Background(),time.Second)
+		ctx,cancel := context.WithTimeout(context.Background(),time.Second)
+		defer cancel()
 		var reply int
 		err:=client.Call(ctx,"Bar.Timeout",1,&reply)
 		_assert(err!=nil&&strings.Contains(err.Error(),ctx.Err().Error()),"expect a timeout error")
@@ -70,19 +133,138 @@ func TestClient_Call(t *testing.T) {
 
 func TestXDial(t *testing.T) {
 	if runtime.GOOS =="linux"{
-		ch :=make(chan struct{})
 		addr :="/tmp/minirpc.sock"
-		go func() {
-			_ = os.Remove(addr)
-			l,err:=net.Listen("unix",addr)
-			if err != nil {
-				t.Fatal("failed to listen unix socket")
-			}
-			ch <- struct{}{}
-			Accept(l)
-		}()
-		<-ch
-		_, err := XDial("unix@" + addr)
+		_ = os.Remove(addr)
+		l,err:=net.Listen("unix",addr)
+		if err != nil {
+			t.Fatal("failed to listen unix socket")
+		}
+		go Accept(l)
+		_, err = XDial("unix@" + addr)
 		_assert(err == nil, "failed to connect unix socket")
 	}
-}
\ No newline at end of file
+}
+
+// 同一组调用分别使用 Gob、Json 和 Msgpack 三种编解码方式执行，结果应当一致。
+func TestClient_Codec(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	for _, typ := range []codec.Type{codec.GobType, codec.JsonType, codec.MsgpackType} {
+		t.Run(string(typ), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &Option{CodecType: typ})
+			_assert(err == nil, "failed to dial with %s: %v", typ, err)
+			defer func() { _ = client.Close() }()
+			var reply int
+			err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err == nil && reply == 3, "expect 3, got %d (%v)", reply, err)
+			err = client.Call(context.Background(), "Foo.Unknown", Args{}, &reply)
+			_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect a method error")
+			err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 2, Num2: 3}, &reply)
+			_assert(err == nil && reply == 5, "client should be usable after an error reply")
+			var records []Record
+			err = client.Call(context.Background(), "Store.Records", 3, &records)
+			_assert(err == nil && len(records) == 3, "expect 3 records, got %d (%v)", len(records), err)
+			r := records[2]
+			_assert(r.Name == "record-2" && len(r.Tags) == 2 && r.Attrs["score"] == 1 && r.Next != nil && r.Next.ID == 1,
+				"unexpected record %+v", r)
+		})
+	}
+}
+
+// 通过 codec.Register 注册的外部编解码器，客户端和服务端都可以直接使用。
+func TestClient_registeredCodec(t *testing.T) {
+	t.Parallel()
+	const typ codec.Type = "application/x-test-gob"
+	_ = codec.Register(typ, codec.NewGobCodec)
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, err := Dial("tcp", <-addrCh, &Option{CodecType: typ})
+	_assert(err == nil, "failed to dial with %s: %v", typ, err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, got %d (%v)", reply, err)
+}
+
+// 较大的回复应当被压缩，服务端不支持的压缩算法退回不压缩，调用仍然成功。
+func TestClient_Compression(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	for _, c := range []Compression{CompressGzip, CompressFlate, CompressZlib, "unknown"} {
+		t.Run(string(c), func(t *testing.T) {
+			client, err := Dial("tcp", addr, &Option{Compression: c})
+			_assert(err == nil, "failed to dial: %v", err)
+			defer func() { _ = client.Close() }()
+			var records []Record
+			err = client.Call(context.Background(), "Store.Records", 200, &records)
+			_assert(err == nil && len(records) == 200, "expect 200 records, got %d (%v)", len(records), err)
+			if c == "unknown" {
+				_assert(client.cc.compression == CompressNone, "unsupported compression should be rejected")
+			}
+		})
+	}
+	_assert(DefaultServer.compress.Ratio() > 1, "expect replies to be compressed, ratio %.2f", DefaultServer.compress.Ratio())
+}
+
+// 接受 context.Context 的方法可以读取元数据、客户端地址和截止时间，超时后上下文被取消。
+func TestClient_contextMethod(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, err := Dial("tcp", <-addrCh, &Option{HandleTimeout: time.Second})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var info CtxInfo
+	var md Metadata
+	ctx := WithReplyMetadata(WithMetadata(context.Background(), "request-id", "42"), &md)
+	err = client.Call(ctx, "Ctx.Info", 1, &info)
+	_assert(err == nil, "failed to call Ctx.Info: %v", err)
+	_assert(info.RequestID == "42" && info.Peer != "" && info.HasDeadline, "unexpected context info %+v", info)
+	_assert(md["request-id"] == "42", "expect reply metadata, got %v", md)
+
+	var reply int
+	err = client.Call(context.Background(), "Ctx.Wait", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a handle timeout, got %v", err)
+	select {
+	case err := <-ctxCanceled[1]:
+		_assert(err == context.DeadlineExceeded, "expect the method context to exceed its deadline, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("the method context wasn't canceled")
+	}
+}
+
+// 客户端取消调用后，服务端上正在执行的方法的上下文随之被取消，方法可以及时返回并释放资源；
+// 客户端上下文的截止时间也会传递给服务端。
+func TestClient_cancel(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, err := Dial("tcp", <-addrCh)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+	var reply int
+	err = client.Call(ctx, "Ctx.Wait", 2, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "canceled"), "expect a canceled error, got %v", err)
+	select {
+	case err := <-ctxCanceled[2]:
+		_assert(err == context.Canceled, "expect the method context to be canceled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("the method context wasn't canceled")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var info CtxInfo
+	err = client.Call(ctx, "Ctx.Info", 1, &info)
+	_assert(err == nil && info.HasDeadline, "expect the client deadline to be sent to the server: %v", err)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "client should be usable after a canceled call: %v", err)
+}
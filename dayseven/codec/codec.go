@@ -1,11 +1,19 @@
 package codec
 
-import "io"
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
 
 type Header struct {
 	ServiceMethod string //ServiceMethod 是服务名和方法名，通常与 Go 语言中的结构体和方法相映射。
 	Seq 	      uint64 //Seq 是请求的序号，也可以认为是某个请求的 ID，用来区分不同的请求。
 	Error 		  string //Error 是错误信息，客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中。
+	Metadata      map[string]string //Metadata 是随请求或响应传递的键值对，例如请求 ID、认证令牌、租户和链路追踪信息。
+	Timeout       time.Duration //Timeout 是客户端上下文距离截止时间剩余的时长，服务端据此设置请求的截止时间，0 表示没有截止时间。
 }
 //抽象出对消息体进行编解码的接口 Codec
 
@@ -19,15 +27,55 @@ type Codec interface {
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 type Type string
 
-//Gob 和 Json，但是实际代码中只实现了 Gob 一种
+//内置 Gob 和 Json 两种编解码方式，其他编解码方式可以通过 Register 注册
 const (
 	GobType  Type = "application/gob"
-	JsonType Type = "application/json" // not implemented
+	JsonType Type = "application/json"
+)
+
+// codecs 保存所有已注册的编解码方式，Register 可能在运行时被并发调用，由 mu 保护。
+var (
+	mu     sync.RWMutex
+	codecs = make(map[Type]NewCodecFunc)
 )
 
-var NewCodecFuncMap map[Type]NewCodecFunc
 func init()  {
-	NewCodecFuncMap = make(map[Type]NewCodecFunc)
-	NewCodecFuncMap[GobType] = NewGobCodec
-	NewCodecFuncMap[JsonType] = NewGobCodec
-}
\ No newline at end of file
+	_ = Register(GobType, NewGobCodec)
+	_ = Register(JsonType, NewJsonCodec)
+}
+
+// Register makes a codec available by the provided type.
+// If Register is called twice with the same type or if f is nil, it returns an error.
+// 外部的编解码器（例如 msgpack、protobuf）可以在自己的包中调用 Register 完成注册，无需修改本包。
+func Register(t Type, f NewCodecFunc) error {
+	if f == nil {
+		return fmt.Errorf("rpc codec: Register codec %s is nil", t)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if _, dup := codecs[t]; dup {
+		return fmt.Errorf("rpc codec: Register called twice for codec %s", t)
+	}
+	codecs[t] = f
+	return nil
+}
+
+// Lookup returns the NewCodecFunc registered for t.
+func Lookup(t Type) (NewCodecFunc, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, ok := codecs[t]
+	return f, ok
+}
+
+// Types returns a sorted list of the registered codec types.
+func Types() []Type {
+	mu.RLock()
+	defer mu.RUnlock()
+	types := make([]Type, 0, len(codecs))
+	for t := range codecs {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
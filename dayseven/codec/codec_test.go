@@ -0,0 +1,54 @@
+package codec
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	if err := Register(GobType, NewGobCodec); err == nil {
+		t.Fatal("expect an error when registering a codec twice")
+	}
+	if err := Register("application/x-nil", nil); err == nil {
+		t.Fatal("expect an error when registering a nil codec")
+	}
+	var wg sync.WaitGroup
+	for _, typ := range []Type{"application/x-test1", "application/x-test2", "application/x-test3"} {
+		unregisterOnCleanup(t, typ)
+		wg.Add(1)
+		go func(typ Type) {
+			defer wg.Done()
+			if err := Register(typ, NewJsonCodec); err != nil {
+				t.Error(err)
+			}
+		}(typ)
+	}
+	wg.Wait()
+	if _, ok := Lookup("application/x-test2"); !ok {
+		t.Fatal("failed to look up a registered codec")
+	}
+	types := Types()
+	for i := 1; i < len(types); i++ {
+		if types[i-1] >= types[i] {
+			t.Fatalf("Types should be sorted, got %v", types)
+		}
+	}
+	found := 0
+	for _, typ := range types {
+		if typ == GobType || typ == JsonType || typ == "application/x-test1" {
+			found++
+		}
+	}
+	if found != 3 {
+		t.Fatalf("registered codec types are missing, got %v", types)
+	}
+}
+
+// unregisterOnCleanup 在测试结束时删除注册的 t，全局的注册表不受测试影响。
+func unregisterOnCleanup(tb testing.TB, t Type) {
+	tb.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(codecs, t)
+	})
+}
@@ -0,0 +1,774 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MsgpackType 使用 MessagePack 编码，便于和 Python、Node 等语言的服务互通。
+const MsgpackType Type = "application/msgpack"
+
+func init() {
+	_ = Register(MsgpackType, NewMsgpackCodec)
+}
+
+// MsgpackCodec 与 GobCodec 结构一致，编解码由本文件中实现的 msgpack 编码器完成。
+// 结构体被编码为以字段名为键的 map，字段名可以通过 `msgpack:"name"` 标签修改，`msgpack:"-"` 表示忽略该字段。
+// 匿名嵌入的结构体不会被展开，而是作为一个以类型名为键的字段编码。
+type MsgpackCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *msgpackDecoder
+}
+
+var _ Codec = (*MsgpackCodec)(nil)
+
+func NewMsgpackCodec(conn io.ReadWriteCloser) Codec {
+	return &MsgpackCodec{
+		conn: conn,
+		buf:  bufio.NewWriter(conn),
+		dec:  &msgpackDecoder{r: bufio.NewReader(conn), src: conn},
+	}
+}
+
+func (c *MsgpackCodec) Close() error {
+	return c.conn.Close()
+}
+
+func (c *MsgpackCodec) ReadHeader(h *Header) error {
+	return c.dec.Decode(h)
+}
+
+// ReadBody 传入 nil 时跳过这一条消息体。
+func (c *MsgpackCodec) ReadBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *MsgpackCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	var b []byte
+	if b, err = appendMsgpack(b, reflect.ValueOf(h)); err != nil {
+		log.Println("rpc:msgpack error encoding header:", err)
+		return
+	}
+	if b, err = appendMsgpack(b, reflect.ValueOf(body)); err != nil {
+		log.Println("rpc:msgpack error encoding body:", err)
+		return
+	}
+	_, err = c.buf.Write(b)
+	return
+}
+
+// msgpack 的格式编码，见 https://github.com/msgpack/msgpack/blob/master/spec.md
+const (
+	mpNil      = 0xc0
+	mpFalse    = 0xc2
+	mpTrue     = 0xc3
+	mpBin8     = 0xc4
+	mpBin16    = 0xc5
+	mpBin32    = 0xc6
+	mpExt8     = 0xc7
+	mpExt16    = 0xc8
+	mpExt32    = 0xc9
+	mpFloat32  = 0xca
+	mpFloat64  = 0xcb
+	mpUint8    = 0xcc
+	mpUint16   = 0xcd
+	mpUint32   = 0xce
+	mpUint64   = 0xcf
+	mpInt8     = 0xd0
+	mpInt16    = 0xd1
+	mpInt32    = 0xd2
+	mpInt64    = 0xd3
+	mpFixExt1  = 0xd4
+	mpFixExt2  = 0xd5
+	mpFixExt4  = 0xd6
+	mpFixExt8  = 0xd7
+	mpFixExt16 = 0xd8
+	mpStr8     = 0xd9
+	mpStr16    = 0xda
+	mpStr32    = 0xdb
+	mpArray16  = 0xdc
+	mpArray32  = 0xdd
+	mpMap16    = 0xde
+	mpMap32    = 0xdf
+)
+
+// msgpackField 描述结构体中一个需要编解码的字段。
+type msgpackField struct {
+	name  string
+	index int
+}
+
+var msgpackFieldCache sync.Map // map[reflect.Type][]msgpackField
+
+func msgpackFields(t reflect.Type) []msgpackField {
+	if fields, ok := msgpackFieldCache.Load(t); ok {
+		return fields.([]msgpackField)
+	}
+	var fields []msgpackField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("msgpack"); tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		fields = append(fields, msgpackField{name: name, index: i})
+	}
+	msgpackFieldCache.Store(t, fields)
+	return fields
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+func appendMsgpackUint(b []byte, u uint64) []byte {
+	switch {
+	case u < 1<<7:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, mpUint8, byte(u))
+	case u <= math.MaxUint16:
+		return appendUint16(append(b, mpUint16), uint16(u))
+	case u <= math.MaxUint32:
+		return appendUint32(append(b, mpUint32), uint32(u))
+	default:
+		return appendUint64(append(b, mpUint64), u)
+	}
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendMsgpackUint(b, uint64(i))
+	case i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt8:
+		return append(b, mpInt8, byte(i))
+	case i >= math.MinInt16:
+		return appendUint16(append(b, mpInt16), uint16(i))
+	case i >= math.MinInt32:
+		return appendUint32(append(b, mpInt32), uint32(i))
+	default:
+		return appendUint64(append(b, mpInt64), uint64(i))
+	}
+}
+
+// appendMsgpackLen 写出 str、bin、array、map 的类型和长度，fix 为对应的 fix 格式前缀，fixMax 为 fix 格式能表示的最大长度。
+func appendMsgpackLen(b []byte, n int, fix byte, fixMax int, c8, c16, c32 byte) []byte {
+	switch {
+	case n <= fixMax:
+		return append(b, fix|byte(n))
+	case c8 != 0 && n <= math.MaxUint8:
+		return append(b, c8, byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, c16), uint16(n))
+	default:
+		return appendUint32(append(b, c32), uint32(n))
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	b = appendMsgpackLen(b, len(s), 0xa0, 31, mpStr8, mpStr16, mpStr32)
+	return append(b, s...)
+}
+
+func appendMsgpackBytes(b []byte, p []byte) []byte {
+	// bin 格式没有 fix 形式，fixMax 为 -1 即可跳过
+	b = appendMsgpackLen(b, len(p), 0, -1, mpBin8, mpBin16, mpBin32)
+	return append(b, p...)
+}
+
+func appendMsgpack(b []byte, v reflect.Value) ([]byte, error) {
+	if !v.IsValid() {
+		return append(b, mpNil), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, mpTrue), nil
+		}
+		return append(b, mpFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendMsgpackInt(b, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return appendMsgpackUint(b, v.Uint()), nil
+	case reflect.Float32:
+		return appendUint32(append(b, mpFloat32), math.Float32bits(float32(v.Float()))), nil
+	case reflect.Float64:
+		return appendUint64(append(b, mpFloat64), math.Float64bits(v.Float())), nil
+	case reflect.String:
+		return appendMsgpackString(b, v.String()), nil
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		return appendMsgpack(b, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendMsgpackBytes(b, v.Bytes()), nil
+		}
+		fallthrough
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			p := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(p), v)
+			return appendMsgpackBytes(b, p), nil
+		}
+		var err error
+		b = appendMsgpackLen(b, v.Len(), 0x90, 15, 0, mpArray16, mpArray32)
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendMsgpack(b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return append(b, mpNil), nil
+		}
+		keys := v.MapKeys()
+		// 字符串键排序后编码，保证同样的 map 得到同样的字节
+		if v.Type().Key().Kind() == reflect.String {
+			sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		}
+		var err error
+		b = appendMsgpackLen(b, len(keys), 0x80, 15, 0, mpMap16, mpMap32)
+		for _, k := range keys {
+			if b, err = appendMsgpack(b, k); err != nil {
+				return nil, err
+			}
+			if b, err = appendMsgpack(b, v.MapIndex(k)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Struct:
+		fields := msgpackFields(v.Type())
+		var err error
+		b = appendMsgpackLen(b, len(fields), 0x80, 15, 0, mpMap16, mpMap32)
+		for _, f := range fields {
+			b = appendMsgpackString(b, f.name)
+			if b, err = appendMsgpack(b, v.Field(f.index)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("msgpack: unsupported type %s", v.Type())
+}
+
+// maxMsgpackDepth 是 array 和 map 嵌套的最大层数。解码是递归进行的，
+// 不限制层数时，一个由 0x91 组成的载荷就能让协程栈溢出，这是 recover 无法捕获的致命错误。
+const maxMsgpackDepth = 10000
+
+type msgpackDecoder struct {
+	r     *bufio.Reader
+	src   io.Reader // r 读取的数据源，可以知道剩余长度时（例如 *bytes.Buffer）用来检查长度
+	depth int       // 当前的嵌套层数
+}
+
+// enter 进入下一层嵌套，超过 maxMsgpackDepth 时返回错误，调用方需要在返回之后调用 leave。
+func (d *msgpackDecoder) enter() error {
+	d.depth++
+	if d.depth > maxMsgpackDepth {
+		return fmt.Errorf("msgpack: exceeded max depth of %d", maxMsgpackDepth)
+	}
+	return nil
+}
+
+func (d *msgpackDecoder) leave() {
+	d.depth--
+}
+
+// Decode 将下一个值解码到 v 中，v 为 nil 时跳过这个值。
+func (d *msgpackDecoder) Decode(v interface{}) error {
+	if v == nil {
+		return d.skip()
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("msgpack: Decode(non-pointer %T)", v)
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	return d.decode(c, rv.Elem())
+}
+
+func (d *msgpackDecoder) readN(n int) ([]byte, error) {
+	p := make([]byte, n)
+	_, err := io.ReadFull(d.r, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return p, err
+}
+
+func (d *msgpackDecoder) readUint(size int) (uint64, error) {
+	p, err := d.readN(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(p[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(p)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(p)), nil
+	default:
+		return binary.BigEndian.Uint64(p), nil
+	}
+}
+
+// readLen 读取 str、bin、array、map 的长度，c 不是这几种格式时 ok 为 false。
+func (d *msgpackDecoder) readLen(c byte) (kind byte, n int, ok bool, err error) {
+	var u uint64
+	switch {
+	case c&0xe0 == 0xa0:
+		return 0xa0, int(c & 0x1f), true, nil
+	case c&0xf0 == 0x90:
+		return 0x90, int(c & 0x0f), true, nil
+	case c&0xf0 == 0x80:
+		return 0x80, int(c & 0x0f), true, nil
+	case c == mpStr8, c == mpBin8:
+		u, err = d.readUint(1)
+	case c == mpStr16, c == mpBin16, c == mpArray16, c == mpMap16:
+		u, err = d.readUint(2)
+	case c == mpStr32, c == mpBin32, c == mpArray32, c == mpMap32:
+		u, err = d.readUint(4)
+	default:
+		return 0, 0, false, nil
+	}
+	switch c {
+	case mpStr8, mpStr16, mpStr32:
+		kind = 0xa0
+	case mpBin8, mpBin16, mpBin32:
+		kind = mpBin8
+	case mpArray16, mpArray32:
+		kind = 0x90
+	default:
+		kind = 0x80
+	}
+	if err != nil {
+		return 0, 0, true, err
+	}
+	if err := d.checkLen(kind, u); err != nil {
+		return 0, 0, true, err
+	}
+	return kind, int(u), true, nil
+}
+
+// checkLen 在按照长度 n 分配内存之前检查它不超过剩余的字节数，避免一个伪造的长度导致分配巨大的内存。
+// str、bin 的每个元素占 1 个字节，array 的每个元素至少占 1 个字节，map 的每个键值对至少占 2 个字节。
+// 数据源不能报告剩余长度时只检查 n 不溢出 int，帧的载荷总是 *bytes.Buffer。
+func (d *msgpackDecoder) checkLen(kind byte, n uint64) error {
+	l, ok := d.src.(interface{ Len() int })
+	if !ok {
+		if n > math.MaxInt32 {
+			return fmt.Errorf("msgpack: length %d exceeds %d", n, math.MaxInt32)
+		}
+		return nil
+	}
+	left := uint64(d.r.Buffered() + l.Len())
+	if kind == 0x80 {
+		left /= 2
+	}
+	if n > left {
+		return fmt.Errorf("msgpack: length %d exceeds the remaining %d bytes", n, left)
+	}
+	return nil
+}
+
+// readNumber 读取整数或浮点数，isFloat 为 true 时结果在 f 中，否则有符号整数在 i 中、无符号整数在 u 中。
+func (d *msgpackDecoder) readNumber(c byte) (i int64, u uint64, f float64, signed, isFloat, ok bool, err error) {
+	switch {
+	case c <= 0x7f:
+		return 0, uint64(c), 0, false, false, true, nil
+	case c >= 0xe0:
+		return int64(int8(c)), 0, 0, true, false, true, nil
+	}
+	switch c {
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		u, err = d.readUint(1 << (c - mpUint8))
+		return 0, u, 0, false, false, true, err
+	case mpInt8:
+		u, err = d.readUint(1)
+		return int64(int8(u)), 0, 0, true, false, true, err
+	case mpInt16:
+		u, err = d.readUint(2)
+		return int64(int16(u)), 0, 0, true, false, true, err
+	case mpInt32:
+		u, err = d.readUint(4)
+		return int64(int32(u)), 0, 0, true, false, true, err
+	case mpInt64:
+		u, err = d.readUint(8)
+		return int64(u), 0, 0, true, false, true, err
+	case mpFloat32:
+		u, err = d.readUint(4)
+		return 0, 0, float64(math.Float32frombits(uint32(u))), false, true, true, err
+	case mpFloat64:
+		u, err = d.readUint(8)
+		return 0, 0, math.Float64frombits(u), false, true, true, err
+	}
+	return 0, 0, 0, false, false, false, nil
+}
+
+func (d *msgpackDecoder) decode(c byte, v reflect.Value) error {
+	if c == mpNil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.decode(c, v.Elem())
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("msgpack: cannot decode into non-empty interface %s", v.Type())
+		}
+		x, err := d.decodeAny(c)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+	if c == mpTrue || c == mpFalse {
+		if v.Kind() != reflect.Bool {
+			return fmt.Errorf("msgpack: cannot decode bool into %s", v.Type())
+		}
+		v.SetBool(c == mpTrue)
+		return nil
+	}
+	if i, u, f, signed, isFloat, ok, err := d.readNumber(c); ok {
+		if err != nil {
+			return err
+		}
+		return setMsgpackNumber(v, i, u, f, signed, isFloat)
+	}
+	kind, n, ok, err := d.readLen(c)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("msgpack: unsupported format 0x%x for %s", c, v.Type())
+	}
+	switch kind {
+	case 0xa0, mpBin8:
+		p, err := d.readN(n)
+		if err != nil {
+			return err
+		}
+		switch {
+		case v.Kind() == reflect.String:
+			v.SetString(string(p))
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			v.SetBytes(p)
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			reflect.Copy(v, reflect.ValueOf(p))
+		default:
+			return fmt.Errorf("msgpack: cannot decode string into %s", v.Type())
+		}
+		return nil
+	case 0x90:
+		switch v.Kind() {
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		case reflect.Array:
+		default:
+			return fmt.Errorf("msgpack: cannot decode array into %s", v.Type())
+		}
+		for i := 0; i < n; i++ {
+			if i >= v.Len() {
+				if err := d.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeNext(v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return d.decodeMap(n, v)
+	}
+}
+
+func (d *msgpackDecoder) decodeNext(v reflect.Value) error {
+	defer d.leave()
+	if err := d.enter(); err != nil {
+		return err
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	return d.decode(c, v)
+}
+
+func (d *msgpackDecoder) decodeMap(n int, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		}
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := d.decodeNext(key); err != nil {
+				return err
+			}
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := d.decodeNext(elem); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, elem)
+		}
+		return nil
+	case reflect.Struct:
+		fields := msgpackFields(v.Type())
+		for i := 0; i < n; i++ {
+			var name string
+			if err := d.decodeNext(reflect.ValueOf(&name).Elem()); err != nil {
+				return err
+			}
+			field := -1
+			for _, f := range fields {
+				if f.name == name {
+					field = f.index
+					break
+				}
+				if field < 0 && strings.EqualFold(f.name, name) {
+					field = f.index
+				}
+			}
+			if field < 0 {
+				// 对方发送了本地结构体中不存在的字段，直接跳过
+				if err := d.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.decodeNext(v.Field(field)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("msgpack: cannot decode map into %s", v.Type())
+}
+
+func setMsgpackNumber(v reflect.Value, i int64, u uint64, f float64, signed, isFloat bool) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if isFloat {
+			break
+		}
+		if !signed {
+			if u > math.MaxInt64 {
+				break
+			}
+			i = int64(u)
+		}
+		if v.OverflowInt(i) {
+			break
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if isFloat || (signed && i < 0) {
+			break
+		}
+		if signed {
+			u = uint64(i)
+		}
+		if v.OverflowUint(u) {
+			break
+		}
+		v.SetUint(u)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case isFloat:
+		case signed:
+			f = float64(i)
+		default:
+			f = float64(u)
+		}
+		v.SetFloat(f)
+		return nil
+	}
+	return fmt.Errorf("msgpack: cannot decode number into %s", v.Type())
+}
+
+// decodeAny 解码到 interface{} 时使用的默认类型：
+// 整数为 int64 或 uint64，浮点数为 float64，str 为 string，bin 为 []byte，
+// array 为 []interface{}，map 为 map[string]interface{}（键不是字符串时为 map[interface{}]interface{}）。
+func (d *msgpackDecoder) decodeAny(c byte) (interface{}, error) {
+	switch c {
+	case mpNil:
+		return nil, nil
+	case mpTrue, mpFalse:
+		return c == mpTrue, nil
+	}
+	if i, u, f, signed, isFloat, ok, err := d.readNumber(c); ok {
+		switch {
+		case err != nil:
+			return nil, err
+		case isFloat:
+			return f, nil
+		case signed:
+			return i, nil
+		case u <= math.MaxInt64:
+			return int64(u), nil
+		default:
+			return u, nil
+		}
+	}
+	kind, n, ok, err := d.readLen(c)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("msgpack: unsupported format 0x%x", c)
+	}
+	switch kind {
+	case 0xa0:
+		p, err := d.readN(n)
+		return string(p), err
+	case mpBin8:
+		return d.readN(n)
+	case 0x90:
+		a := make([]interface{}, n)
+		for i := range a {
+			if err := d.decodeNext(reflect.ValueOf(&a[i]).Elem()); err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+	keys := make([]interface{}, n)
+	values := make([]interface{}, n)
+	stringKeys := true
+	for i := 0; i < n; i++ {
+		if err := d.decodeNext(reflect.ValueOf(&keys[i]).Elem()); err != nil {
+			return nil, err
+		}
+		if err := d.decodeNext(reflect.ValueOf(&values[i]).Elem()); err != nil {
+			return nil, err
+		}
+		if _, ok := keys[i].(string); !ok {
+			stringKeys = false
+		}
+	}
+	if stringKeys {
+		m := make(map[string]interface{}, n)
+		for i, k := range keys {
+			m[k.(string)] = values[i]
+		}
+		return m, nil
+	}
+	m := make(map[interface{}]interface{}, n)
+	for i, k := range keys {
+		if !reflect.TypeOf(k).Comparable() {
+			return nil, errors.New("msgpack: unhashable map key")
+		}
+		m[k] = values[i]
+	}
+	return m, nil
+}
+
+// skip 跳过下一个值，包括 ext 格式。
+func (d *msgpackDecoder) skip() error {
+	defer d.leave()
+	if err := d.enter(); err != nil {
+		return err
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch {
+	case c <= 0x7f, c >= 0xe0, c == mpNil, c == mpTrue, c == mpFalse:
+		return nil
+	}
+	if _, _, _, _, _, ok, err := d.readNumber(c); ok {
+		return err
+	}
+	ext := 0
+	switch c {
+	case mpFixExt1, mpFixExt2, mpFixExt4, mpFixExt8, mpFixExt16:
+		ext = 1 + 1<<(c-mpFixExt1)
+	case mpExt8, mpExt16, mpExt32:
+		n, err := d.readUint(1 << (c - mpExt8))
+		if err != nil {
+			return err
+		}
+		ext = 1 + int(n)
+	}
+	if ext > 0 {
+		_, err = d.r.Discard(ext)
+		return err
+	}
+	kind, n, ok, err := d.readLen(c)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("msgpack: unsupported format 0x%x", c)
+	}
+	switch kind {
+	case 0x90:
+	case 0x80:
+		n *= 2
+	default:
+		_, err = d.r.Discard(n)
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package codec
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type msgpackItem struct {
+	Name    string `msgpack:"name"`
+	Count   uint16
+	Delta   int64
+	Ratio   float32
+	Payload []byte
+	Values  []interface{}
+	Labels  map[string]string
+	Child   *msgpackItem
+	Skipped string `msgpack:"-"`
+	hidden  int
+}
+
+func TestMsgpackCodec(t *testing.T) {
+	var buf bytes.Buffer
+	cc := NewMsgpackCodec(nopRWC{&buf})
+	in := msgpackItem{
+		Name:    "item",
+		Count:   300,
+		Delta:   math.MinInt64,
+		Ratio:   0.5,
+		Payload: []byte{1, 2, 3},
+		Values:  []interface{}{int64(-1), "x", true, nil, 1.5},
+		Labels:  map[string]string{"k": "v"},
+		Child:   &msgpackItem{Name: "child"},
+		Skipped: "skipped",
+		hidden:  1,
+	}
+	h := &Header{ServiceMethod: "Foo.Sum", Seq: math.MaxUint64, Error: "oops", Metadata: map[string]string{"request-id": "1"}}
+	if err := cc.Write(h, &in); err != nil {
+		t.Fatal(err)
+	}
+	// 第二条消息的消息体将被跳过
+	if err := cc.Write(&Header{Seq: 2}, map[int][]string{1: {"a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Write(&Header{Seq: 3}, 42); err != nil {
+		t.Fatal(err)
+	}
+
+	var gotH Header
+	var got msgpackItem
+	if err := cc.ReadHeader(&gotH); err != nil || !reflect.DeepEqual(gotH, *h) {
+		t.Fatalf("header mismatch: %+v (%v)", gotH, err)
+	}
+	if err := cc.ReadBody(&got); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped, in.hidden = "", 0
+	if !reflect.DeepEqual(got, in) {
+		t.Fatalf("body mismatch:\n got %+v\nwant %+v", got, in)
+	}
+	if err := cc.ReadHeader(&gotH); err != nil || gotH.Seq != 2 {
+		t.Fatalf("expect seq 2, got %+v (%v)", gotH, err)
+	}
+	if err := cc.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := cc.ReadHeader(&gotH); err != nil || gotH.Seq != 3 {
+		t.Fatalf("expect seq 3, got %+v (%v)", gotH, err)
+	}
+	if err := cc.ReadBody(&n); err != nil || n != 42 {
+		t.Fatalf("expect 42, got %d (%v)", n, err)
+	}
+}
+
+type nopRWC struct {
+	*bytes.Buffer
+}
+
+func (nopRWC) Close() error { return nil }
+
+// 伪造的长度不能导致按照它分配内存。
+func TestMsgpackCodec_hugeLength(t *testing.T) {
+	var h bytes.Buffer
+	if err := NewMsgpackCodec(nopRWC{&h}).Write(&Header{Seq: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	header := h.Bytes()[:h.Len()-1] // 去掉 nil 消息体
+	bodies := map[string][]byte{
+		"array32": {0xdd, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"map32":   {0xdf, 0xff, 0xff, 0xff, 0xff, 0x01, 0x01},
+		"str32":   {0xdb, 0xff, 0xff, 0xff, 0xff, 'a'},
+		"bin32":   {0xc6, 0x7f, 0xff, 0xff, 0xff, 'a'},
+	}
+	targets := map[string]func() interface{}{
+		"slice":     func() interface{} { return new([]int) },
+		"interface": func() interface{} { return new(interface{}) },
+		"skip":      func() interface{} { return nil },
+	}
+	for name, body := range bodies {
+		for target, newBody := range targets {
+			cc := NewMsgpackCodec(nopRWC{bytes.NewBuffer(append(append([]byte{}, header...), body...))})
+			var gotH Header
+			if err := cc.ReadHeader(&gotH); err != nil {
+				t.Fatal(err)
+			}
+			if err := cc.ReadBody(newBody()); err == nil || !strings.Contains(err.Error(), "exceeds") {
+				t.Fatalf("%s into %s: expect the length to be rejected, got %v", name, target, err)
+			}
+		}
+	}
+}
+
+// 嵌套过深的输入返回错误，而不是让协程栈溢出。
+func TestMsgpackCodec_deepNesting(t *testing.T) {
+	var h bytes.Buffer
+	if err := NewMsgpackCodec(nopRWC{&h}).Write(&Header{Seq: 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	header := h.Bytes()[:h.Len()-1] // 去掉 nil 消息体
+	body := append(bytes.Repeat([]byte{0x91}, 1<<20), mpNil)
+	targets := map[string]func() interface{}{
+		"slice":     func() interface{} { return new([]interface{}) },
+		"interface": func() interface{} { return new(interface{}) },
+		"skip":      func() interface{} { return nil },
+	}
+	for target, newBody := range targets {
+		cc := NewMsgpackCodec(nopRWC{bytes.NewBuffer(append(append([]byte{}, header...), body...))})
+		var gotH Header
+		if err := cc.ReadHeader(&gotH); err != nil {
+			t.Fatal(err)
+		}
+		if err := cc.ReadBody(newBody()); err == nil || !strings.Contains(err.Error(), "max depth") {
+			t.Fatalf("%s: expect the nesting to be rejected, got %v", target, err)
+		}
+	}
+	// 不太深的嵌套仍然可以解码
+	cc := NewMsgpackCodec(nopRWC{bytes.NewBuffer(append(append([]byte{}, header...), append(bytes.Repeat([]byte{0x91}, 100), mpNil)...))})
+	var gotH Header
+	var v interface{}
+	if err := cc.ReadHeader(&gotH); err != nil || cc.ReadBody(&v) != nil {
+		t.Fatal("failed to decode a nested array")
+	}
+}
@@ -0,0 +1,108 @@
+package minirpc
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Compression 是消息体的压缩算法，客户端在 Option 中提出，服务端在握手时确认。
+// 服务端不支持客户端提出的算法时会退回 CompressNone，而不是拒绝连接。
+type Compression string
+
+const (
+	CompressNone  Compression = ""
+	CompressGzip  Compression = "gzip"
+	CompressFlate Compression = "flate" // flate.BestSpeed，速度优先，适合替代 snappy 的场景
+	CompressZlib  Compression = "zlib"  // zlib.BestCompression，压缩率优先，适合替代 zstd 的场景
+)
+
+// DefaultCompressThreshold 是 Option.CompressThreshold 为 0 时使用的阈值，小于该长度的消息不压缩。
+const DefaultCompressThreshold = 1024
+
+type compressor struct {
+	newWriter func(w io.Writer) (io.WriteCloser, error)
+	newReader func(r io.Reader) (io.ReadCloser, error)
+}
+
+var compressors = map[Compression]compressor{
+	CompressGzip: {
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+	CompressFlate: {
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return flate.NewWriter(w, flate.BestSpeed) },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return flate.NewReader(r), nil },
+	},
+	CompressZlib: {
+		newWriter: func(w io.Writer) (io.WriteCloser, error) { return zlib.NewWriterLevel(w, zlib.BestCompression) },
+		newReader: func(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) },
+	},
+}
+
+func compress(c Compression, p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := compressors[c].newWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(p); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompress 解压后的长度同样受 maxFrameLength 限制，防止压缩炸弹。
+func decompress(c Compression, p []byte) ([]byte, error) {
+	r, err := compressors[c].newReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	out, err := io.ReadAll(io.LimitReader(r, maxFrameLength+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxFrameLength {
+		return nil, fmt.Errorf("decompressed length exceeds %d", maxFrameLength)
+	}
+	return out, nil
+}
+
+// compressStats 统计消息压缩前后的字节数，用于在调试页面展示压缩率。
+type compressStats struct {
+	raw  uint64 // 压缩前的字节数
+	wire uint64 // 实际在连接上传输的字节数
+}
+
+func (s *compressStats) add(raw, wire int) {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.raw, uint64(raw))
+	atomic.AddUint64(&s.wire, uint64(wire))
+}
+
+func (s *compressStats) RawBytes() uint64 {
+	return atomic.LoadUint64(&s.raw)
+}
+
+func (s *compressStats) WireBytes() uint64 {
+	return atomic.LoadUint64(&s.wire)
+}
+
+// Ratio 返回压缩率，即压缩前的字节数与传输字节数之比，没有数据时为 1。
+func (s *compressStats) Ratio() float64 {
+	wire := s.WireBytes()
+	if wire == 0 {
+		return 1
+	}
+	return float64(s.RawBytes()) / float64(wire)
+}
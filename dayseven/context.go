@@ -0,0 +1,38 @@
+package minirpc
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+)
+
+// Peer 描述发起请求的客户端。
+type Peer struct {
+	Addr net.Addr // 客户端的地址，连接不是 net.Conn 时为 nil
+	// TLS 是 TLS 连接的状态，双向认证时 TLS.PeerCertificates 中是客户端的证书，明文连接时为 nil
+	TLS *tls.ConnectionState
+}
+
+type peerKey struct{}
+
+// PeerFromContext returns the peer of the request being handled.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(*Peer)
+	return p, ok
+}
+
+// newConnContext 创建连接级别的上下文，连接断开时它被取消，
+// 该连接上所有请求的上下文都由它派生。
+func newConnContext(conn io.ReadWriteCloser) (context.Context, context.CancelFunc) {
+	p := &Peer{}
+	if c, ok := conn.(net.Conn); ok {
+		p.Addr = c.RemoteAddr()
+	}
+	// 握手帧已经读取成功，TLS 握手此时已经完成
+	if c, ok := conn.(*tls.Conn); ok {
+		state := c.ConnectionState()
+		p.TLS = &state
+	}
+	return context.WithCancel(context.WithValue(context.Background(), peerKey{}, p))
+}
@@ -9,16 +9,22 @@ import (
 const debugText = `<html>
 	<body>
 	<title>GeeRPC Services</title>
-	{{range .}}
+	{{with .Compression}}
+	<hr>
+	Compression: {{.RawBytes}} bytes before compression, {{.WireBytes}} bytes on the wire, ratio {{printf "%.2f" .Ratio}}
+	{{end}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Panics</th><th align=center>Denied</th>
 		{{range $name, $mtype := .Method}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
+			<td align=left font=fixed>{{$name}}({{if $mtype.TakesContext}}context.Context, {{end}}{{if $mtype.ArgType}}{{$mtype.ArgType}}{{if $mtype.ReplyType}}, {{end}}{{end}}{{if $mtype.ReplyType}}{{$mtype.ReplyType}}{{end}}) error</td>
 			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=center>{{$mtype.NumPanics}}</td>
+			<td align=center>{{$mtype.NumDenied}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -37,6 +43,11 @@ type debugService struct {
 	Method map[string]*methodType
 }
 
+type debugPage struct {
+	Services    []debugService
+	Compression *compressStats
+}
+
 func (server debugHTTP) ServeHTTP(w http.ResponseWriter,req *http.Request)  {
 	var services []debugService
 	server.serviceMap.Range(func(namei, svci interface{}) bool {
@@ -47,7 +58,7 @@ func (server debugHTTP) ServeHTTP(w http.ResponseWriter,req *http.Request)  {
 		})
 		return true
 	})
-	err :=debug.Execute(w,services)
+	err :=debug.Execute(w,debugPage{Services: services, Compression: &server.compress})
 	if err != nil {
 		_,_ = fmt.Fprintln(w,"rpc:error executing template:",err.Error())
 	}
@@ -0,0 +1,263 @@
+package minirpc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"minirpc/codec"
+)
+
+// ProtocolVersion 是当前的协议版本。
+// 版本 1 使用 JSON 编码的 Option 握手，之后完全依赖编解码器自身的流式分帧；
+// 版本 2 中每一条消息都是一个帧，帧头定长、二进制编码：
+//
+//	| magic(4) | version(1) | codec(1) | flags(2) | seq(8) | length(4) | payload(length) |
+//
+// payload 是编解码器对 Header 和 Body 的编码结果，
+// 由于长度已知，无法识别或解码失败的消息可以直接跳过，而不必断开连接。
+const ProtocolVersion = 2
+
+const (
+	frameHeaderLen = 20
+	maxFrameLength = 64 << 20 // 单个帧的载荷上限，防止恶意的长度字段导致无限制的内存分配
+)
+
+// frameFlag 标记帧的用途，路由帧时只需要查看帧头。
+type frameFlag uint16
+
+const (
+	flagHandshake frameFlag = 1 << iota // 握手帧，载荷是 JSON 编码的 Option
+	flagError                           // 错误帧，载荷是错误信息
+	flagCompressed                      // 载荷经过握手时协商的算法压缩
+	flagCancel                          // 取消帧，客户端不再需要 seq 对应的响应，没有载荷
+	flagGoAway                          // 服务端正在关闭，客户端不应再发起新的调用，没有载荷
+	flagAuth                            // 认证帧，载荷由认证方式决定，与 flagHandshake 同时出现时表示认证的结果
+	flagStream                          // 流中的一条消息，同一个流的所有帧使用同一个 seq
+	flagEndStream                       // 流的结束帧，Header 中带有方法返回的错误
+	flagWindow                          // 流控帧，载荷是 4 字节的窗口增量，即对方还可以再发送的消息数
+	flagOneWay                          // 单向调用，服务端不发送响应，处理出错时也只记录日志
+	flagPush                            // 服务端主动推送的事件，Header.ServiceMethod 是事件名，不对应任何调用
+)
+
+// controlFlags 标记的帧是控制帧，载荷中没有 Header 和 Body。
+const controlFlags = flagCancel | flagGoAway | flagWindow
+
+// codecIDs 记录内置编解码器在帧头中的编号。
+// 通过 codec.Register 注册的外部编解码器编号为 0，表示编解码方式以握手时的 CodecType 为准。
+var codecIDs = map[codec.Type]uint8{
+	codec.GobType:     1,
+	codec.JsonType:    2,
+	codec.MsgpackType: 3,
+}
+
+type frameHeader struct {
+	Magic   uint32
+	Version uint8
+	Codec   uint8
+	Flags   frameFlag
+	Seq     uint64
+	Length  uint32
+}
+
+func (fh *frameHeader) marshal(b []byte) {
+	binary.BigEndian.PutUint32(b[0:4], fh.Magic)
+	b[4] = fh.Version
+	b[5] = fh.Codec
+	binary.BigEndian.PutUint16(b[6:8], uint16(fh.Flags))
+	binary.BigEndian.PutUint64(b[8:16], fh.Seq)
+	binary.BigEndian.PutUint32(b[16:20], fh.Length)
+}
+
+func (fh *frameHeader) unmarshal(b []byte) {
+	fh.Magic = binary.BigEndian.Uint32(b[0:4])
+	fh.Version = b[4]
+	fh.Codec = b[5]
+	fh.Flags = frameFlag(binary.BigEndian.Uint16(b[6:8]))
+	fh.Seq = binary.BigEndian.Uint64(b[8:16])
+	fh.Length = binary.BigEndian.Uint32(b[16:20])
+}
+
+// errMalformedFrame 表示一个帧被完整地读取（或跳过）了，但是无法解码。
+// 连接本身仍然可用，收到这个错误的一方可以根据帧头中的 seq 回复错误。
+var errMalformedFrame = errors.New("rpc: malformed frame")
+
+// frameCodec 在连接上按帧读写消息，并将每个帧的载荷交给 codec.Codec 编解码。
+// 它本身也实现了 codec.Codec，因此 Server 和 Client 的处理逻辑不需要关心分帧。
+type frameCodec struct {
+	conn     io.ReadWriteCloser
+	r        *bufio.Reader
+	w        *bufio.Writer
+	typ      codec.Type
+	newCodec codec.NewCodecFunc
+	frame    frameHeader // 最近一次读到的帧头
+	body     codec.Codec // 最近一次读到的帧的载荷，等待 ReadBody 读取
+	ctrl     []byte      // 最近一次读到的控制帧的载荷
+
+	compression Compression    // 握手时协商的压缩算法
+	threshold   int            // 载荷长度不小于 threshold 时才压缩
+	stats       *compressStats // 可以为 nil
+}
+
+var _ codec.Codec = (*frameCodec)(nil)
+
+func newFrameCodec(conn io.ReadWriteCloser) *frameCodec {
+	return &frameCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+}
+
+// setCodec 设置握手协商出的编解码方式。
+func (c *frameCodec) setCodec(typ codec.Type, f codec.NewCodecFunc) {
+	c.typ = typ
+	c.newCodec = f
+}
+
+// setCompression 设置握手协商出的压缩算法。
+func (c *frameCodec) setCompression(compression Compression, threshold int) {
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	c.compression = compression
+	c.threshold = threshold
+}
+
+// readFrame 读取一个完整的帧，压缩过的载荷会被解压。载荷过长或无法解压时跳过这个帧，返回 errMalformedFrame。
+func (c *frameCodec) readFrame() ([]byte, error) {
+	var b [frameHeaderLen]byte
+	if _, err := io.ReadFull(c.r, b[:]); err != nil {
+		return nil, err
+	}
+	c.frame.unmarshal(b[:])
+	if c.frame.Magic != MagicNumber {
+		return nil, fmt.Errorf("rpc: invalid magic number %x", c.frame.Magic)
+	}
+	if c.frame.Length > maxFrameLength {
+		if _, err := io.CopyN(io.Discard, c.r, int64(c.frame.Length)); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: frame length %d exceeds %d", errMalformedFrame, c.frame.Length, maxFrameLength)
+	}
+	payload := make([]byte, c.frame.Length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return nil, err
+	}
+	if c.frame.Flags&flagCompressed == 0 {
+		c.stats.add(len(payload), len(payload))
+		return payload, nil
+	}
+	if c.compression == CompressNone {
+		return nil, fmt.Errorf("%w: compressed frame without negotiated compression", errMalformedFrame)
+	}
+	raw, err := decompress(c.compression, payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errMalformedFrame, err)
+	}
+	c.stats.add(len(raw), len(payload))
+	return raw, nil
+}
+
+// writeFrame 写出一个帧，sending 锁由调用方持有。
+func (c *frameCodec) writeFrame(flags frameFlag, seq uint64, payload []byte) error {
+	var b [frameHeaderLen]byte
+	fh := frameHeader{
+		Magic:   MagicNumber,
+		Version: ProtocolVersion,
+		Codec:   codecIDs[c.typ],
+		Flags:   flags,
+		Seq:     seq,
+		Length:  uint32(len(payload)),
+	}
+	fh.marshal(b[:])
+	if _, err := c.w.Write(b[:]); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(payload); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+func (c *frameCodec) Close() error {
+	return c.conn.Close()
+}
+
+// ReadHeader 读取下一个帧，并解码出其中的 Header。
+// 载荷无法解码时，h.Seq 取帧头中的 seq，返回 errMalformedFrame。
+func (c *frameCodec) ReadHeader(h *codec.Header) error {
+	c.body = nil
+	payload, err := c.readFrame()
+	if err != nil {
+		if errors.Is(err, errMalformedFrame) {
+			h.Seq = c.frame.Seq
+		}
+		return err
+	}
+	if c.frame.Flags&flagError != 0 {
+		return errors.New(string(payload))
+	}
+	if c.frame.Flags&controlFlags != 0 {
+		// 控制帧不需要解码，调用方根据 c.frame.Flags 和 h.Seq 处理
+		*h = codec.Header{Seq: c.frame.Seq}
+		c.ctrl = payload
+		return nil
+	}
+	body := c.newCodec(nopCloser{bytes.NewBuffer(payload)})
+	if err := body.ReadHeader(h); err != nil {
+		h.Seq = c.frame.Seq
+		return fmt.Errorf("%w: %v", errMalformedFrame, err)
+	}
+	c.body = body
+	return nil
+}
+
+// ReadBody 解码当前帧的 Body，body 为 nil 时直接丢弃，下一个帧不受影响。
+func (c *frameCodec) ReadBody(body interface{}) error {
+	cc := c.body
+	c.body = nil
+	if body == nil || cc == nil {
+		return nil
+	}
+	return cc.ReadBody(body)
+}
+
+// takeBody 取走当前帧的载荷，流中的消息在调用方 Recv 时才解码。
+func (c *frameCodec) takeBody() codec.Codec {
+	cc := c.body
+	c.body = nil
+	return cc
+}
+
+// Write 先将 Header 和 Body 编码到内存中，再作为一个帧写出，
+// 载荷达到压缩阈值且压缩后确实变小时，以压缩后的形式发送。
+// 编码失败不会影响连接，调用方可以继续发送错误响应。
+func (c *frameCodec) Write(h *codec.Header, body interface{}) error {
+	return c.writeMessage(0, h, body)
+}
+
+// writeMessage 与 Write 相同，帧头中额外带上 flags。
+func (c *frameCodec) writeMessage(flags frameFlag, h *codec.Header, body interface{}) error {
+	var buf bytes.Buffer
+	if err := c.newCodec(nopCloser{&buf}).Write(h, body); err != nil {
+		return err
+	}
+	payload := buf.Bytes()
+	if c.compression != CompressNone && len(payload) >= c.threshold {
+		if z, err := compress(c.compression, payload); err == nil && len(z) < len(payload) {
+			payload, flags = z, flags|flagCompressed
+		}
+	}
+	c.stats.add(buf.Len(), len(payload))
+	return c.writeFrame(flags, h.Seq, payload)
+}
+
+// nopCloser 让内存中的缓冲区满足 io.ReadWriteCloser，供编解码器使用。
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
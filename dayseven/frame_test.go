@@ -0,0 +1,52 @@
+package minirpc
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestFrameHeader_marshal(t *testing.T) {
+	fh := frameHeader{Magic: MagicNumber, Version: ProtocolVersion, Codec: 2, Flags: flagHandshake, Seq: 42, Length: 7}
+	var b [frameHeaderLen]byte
+	fh.marshal(b[:])
+	var got frameHeader
+	got.unmarshal(b[:])
+	_assert(got == fh, "frame header round trip failed: %+v", got)
+}
+
+// 不支持的协议版本应当收到明确的错误帧，而不是直接断开连接
+func TestServer_handshakeVersion(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	conn, err := net.Dial("tcp", <-addrCh)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = conn.Close() }()
+	cc := newFrameCodec(conn)
+	payload, _ := json.Marshal(DefaultOption)
+	var b [frameHeaderLen]byte
+	fh := frameHeader{Magic: MagicNumber, Version: 1, Codec: codecIDs[DefaultOption.CodecType], Flags: flagHandshake, Length: uint32(len(payload))}
+	fh.marshal(b[:])
+	_, _ = conn.Write(append(b[:], payload...))
+	reply, err := cc.readFrame()
+	_assert(err == nil && cc.frame.Flags&flagError != 0, "expect an error frame")
+	_assert(strings.Contains(string(reply), "unsupported protocol version"), "unexpected error: %s", reply)
+}
+
+// 消息体无法解码时，只有这一次调用失败，连接仍然可以继续使用
+func TestClient_malformedBody(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	client, err := Dial("tcp", <-addrCh)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", "not args", &reply)
+	_assert(err != nil, "expect a decoding error")
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "client should be usable after a malformed body: %v", err)
+}
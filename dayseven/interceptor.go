@@ -0,0 +1,135 @@
+package minirpc
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// CallInfo describes the call seen by an Interceptor.
+type CallInfo struct {
+	ServiceMethod string      // "Service.Method"
+	Args          interface{} // 解码后的参数，与方法的参数类型相同，双向流方法为 nil
+	Reply         interface{} // 方法的返回值，指针类型，短路调用时可以直接填充，没有返回值的方法为 nil
+	Metadata      Metadata    // 客户端发来的元数据
+	OneWay        bool        // 通过 Client.Notify 发起的调用，不会发送响应
+}
+
+// Handler 完成一次调用，最内层的 Handler 调用服务方法。
+type Handler func(ctx context.Context, info *CallInfo) error
+
+// Interceptor wraps the invocation of a service method.
+// 拦截器调用 next 继续处理，也可以不调用 next 直接返回，从而短路这次调用，
+// 返回的错误会作为响应发送给客户端。日志、认证、监控和参数校验等都可以通过拦截器实现。
+//
+//	server.Use(func(ctx context.Context, info *minirpc.CallInfo, next minirpc.Handler) error {
+//		start := time.Now()
+//		err := next(ctx, info)
+//		log.Printf("%s took %s, err: %v", info.ServiceMethod, time.Since(start), err)
+//		return err
+//	})
+type Interceptor func(ctx context.Context, info *CallInfo, next Handler) error
+
+// Use adds interceptors that wrap every call handled by the server.
+// 拦截器按照注册的顺序执行，先注册的在外层。
+func (server *Server) Use(interceptors ...Interceptor) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.interceptors = append(server.interceptors, interceptors...)
+}
+
+// UseService adds interceptors that only wrap calls to the named service.
+// 它们在 Use 注册的拦截器之后、服务方法之前执行。
+func (server *Server) UseService(name string, interceptors ...Interceptor) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.svcInterceptors == nil {
+		server.svcInterceptors = make(map[string][]Interceptor)
+	}
+	server.svcInterceptors[name] = append(server.svcInterceptors[name], interceptors...)
+}
+
+// Use adds interceptors to the DefaultServer.
+func Use(interceptors ...Interceptor) {
+	DefaultServer.Use(interceptors...)
+}
+
+// interceptorsFor 返回服务 name 上生效的所有拦截器。
+func (server *Server) interceptorsFor(name string) []Interceptor {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	svc := server.svcInterceptors[name]
+	if len(svc) == 0 {
+		return server.interceptors
+	}
+	chain := make([]Interceptor, 0, len(server.interceptors)+len(svc))
+	chain = append(chain, server.interceptors...)
+	return append(chain, svc...)
+}
+
+// invoke 经过拦截器调用服务方法，拦截器发生 panic 时同样返回 *PanicError。
+// 权限检查在所有拦截器之前进行，拦截器无法绕过 Policy。
+func (server *Server) invoke(ctx context.Context, req *request) (err error) {
+	if server.Policy != nil {
+		principal, _ := PrincipalFromContext(ctx)
+		if err = server.Policy.Authorize(principal, req.h.ServiceMethod); err != nil {
+			atomic.AddUint64(&req.mtype.numDenied, 1)
+			return err
+		}
+	}
+	handler := Handler(func(ctx context.Context, info *CallInfo) error {
+		return req.svc.call(req.mtype, ctx, req.argv, req.replyv)
+	})
+	chain := server.interceptorsFor(req.svc.name)
+	if len(chain) == 0 {
+		return handler(ctx, nil)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			// 服务方法的 panic 已经在 service.call 中恢复，这里只会是拦截器的 panic
+			err = req.mtype.newPanicError(req.h.ServiceMethod, r)
+		}
+	}()
+	for i := len(chain) - 1; i >= 0; i-- {
+		ic, next := chain[i], handler
+		handler = func(ctx context.Context, info *CallInfo) error {
+			return ic(ctx, info, next)
+		}
+	}
+	info := &CallInfo{
+		ServiceMethod: req.h.ServiceMethod,
+		Metadata:      MetadataFromContext(ctx),
+		OneWay:        req.oneWay,
+	}
+	if req.argv.IsValid() {
+		info.Args = req.argv.Interface()
+	}
+	if req.replyv.IsValid() {
+		info.Reply = req.replyv.Interface()
+	}
+	return handler(ctx, info)
+}
+
+// Invoker 完成一次客户端调用，最内层的 Invoker 把请求发送给服务端并等待响应。
+type Invoker func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+
+// ClientInterceptor wraps Client.Call.
+// 拦截器调用 invoker 继续处理，可以在调用前后注入元数据、记录日志和耗时，
+// 也可以多次调用 invoker 实现重试，或者不调用 invoker 直接返回。
+//
+//	opt := &minirpc.Option{Interceptors: []minirpc.ClientInterceptor{
+//		func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker minirpc.Invoker) error {
+//			return invoker(minirpc.WithMetadata(ctx, "request-id", newID()), serviceMethod, args, reply)
+//		},
+//	}}
+type ClientInterceptor func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error
+
+// chainClientInterceptors 按顺序把拦截器包装在 invoker 外面，先出现的在外层。
+func chainClientInterceptors(interceptors []ClientInterceptor, invoker Invoker) Invoker {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		ic, next := interceptors[i], invoker
+		invoker = func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+			return ic(ctx, serviceMethod, args, reply, next)
+		}
+	}
+	return invoker
+}
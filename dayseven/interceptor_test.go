@@ -0,0 +1,137 @@
+package minirpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 拦截器按照注册顺序执行，可以看到参数、返回值、元数据和错误，也可以短路调用。
+func TestServer_Use(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	var foo Foo
+	var s Sleeper
+	_ = server.Register(&foo)
+	_ = server.Register(&s)
+
+	var mu sync.Mutex
+	var trace []string
+	record := func(name string) Interceptor {
+		return func(ctx context.Context, info *CallInfo, next Handler) error {
+			mu.Lock()
+			trace = append(trace, name+":"+info.ServiceMethod)
+			mu.Unlock()
+			return next(ctx, info)
+		}
+	}
+	server.Use(record("a"), record("b"))
+	server.Use(func(ctx context.Context, info *CallInfo, next Handler) error {
+		err := next(ctx, info)
+		if info.ServiceMethod == "Foo.Sum" && err == nil {
+			*info.Reply.(*int) *= 10
+		}
+		return err
+	})
+	server.UseService("Foo", func(ctx context.Context, info *CallInfo, next Handler) error {
+		if info.Metadata["token"] != "secret" {
+			return errors.New("unauthenticated")
+		}
+		if args := info.Args.(Args); args.Num1 < 0 {
+			return errors.New("invalid args")
+		}
+		return next(ctx, info)
+	})
+
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "unauthenticated"), "expect the call to be short-circuited: %v", err)
+
+	ctx := WithMetadata(context.Background(), "token", "secret")
+	err = client.Call(ctx, "Foo.Sum", Args{Num1: -1, Num2: 2}, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "invalid args"), "expect the args to be rejected: %v", err)
+	err = client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 30, "expect 30, got %d: %v", reply, err)
+
+	// 按服务注册的拦截器不会作用到其他服务上
+	err = client.Call(context.Background(), "Sleeper.Sleep", 1, &reply)
+	_assert(err == nil && reply == 1, "failed to call Sleeper.Sleep: %v", err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	_assert(len(trace) == 8 && trace[0] == "a:Foo.Sum" && trace[1] == "b:Foo.Sum" && trace[7] == "b:Sleeper.Sleep",
+		"unexpected trace: %v", trace)
+}
+
+// 拦截器发生 panic 时同样转换为错误响应。
+func TestServer_interceptorPanic(t *testing.T) {
+	t.Parallel()
+	server, addr := startSleeperServer()
+	defer func() { _ = server.Close() }()
+	server.OnPanic = func(*PanicError) {}
+	server.UseService("Sleeper", func(ctx context.Context, info *CallInfo, next Handler) error {
+		panic("interceptor")
+	})
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Sleeper.Sleep", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "Sleeper.Sleep panic: interceptor"), "unexpected error: %v", err)
+	svci, _ := server.serviceMap.Load("Sleeper")
+	mtype := svci.(*service).method["Sleep"]
+	_assert(mtype.NumPanics() == 1, "the interceptor panic should be counted, got %d", mtype.NumPanics())
+}
+
+// 客户端拦截器按顺序包装 Call，可以注入元数据，也可以重试。
+func TestClient_interceptors(t *testing.T) {
+	t.Parallel()
+	addrCh := make(chan string)
+	go startServer(addrCh)
+	addr := <-addrCh
+	var mu sync.Mutex
+	var trace []string
+	attempts := 0
+	opt := &Option{Interceptors: []ClientInterceptor{
+		func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error {
+			mu.Lock()
+			trace = append(trace, "outer:"+serviceMethod)
+			mu.Unlock()
+			return invoker(WithMetadata(ctx, "request-id", "42"), serviceMethod, args, reply)
+		},
+		func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error {
+			var err error
+			for i := 0; i < 2; i++ {
+				mu.Lock()
+				attempts++
+				mu.Unlock()
+				if err = invoker(ctx, serviceMethod, args, reply); err == nil {
+					break
+				}
+			}
+			return err
+		},
+	}}
+	client, err := Dial("tcp", addr, opt)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var info CtxInfo
+	err = client.Call(context.Background(), "Ctx.Info", 0, &info)
+	_assert(err == nil && info.RequestID == "42", "expect the injected metadata: %v %v", err, info)
+	err = client.Call(context.Background(), "Foo.Missing", 0, &info)
+	_assert(err != nil, "expect an error for a missing method")
+	mu.Lock()
+	defer mu.Unlock()
+	_assert(len(trace) == 2 && trace[1] == "outer:Foo.Missing" && attempts == 3, "unexpected trace %v, attempts %d", trace, attempts)
+}
@@ -0,0 +1,113 @@
+package minirpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Metadata 是随请求和响应一起传递的键值对，例如请求 ID、认证令牌、租户和链路追踪信息，
+// 它保存在 codec.Header 中，因此与具体的编解码方式无关。
+type Metadata map[string]string
+
+// Copy 返回 md 的副本。
+func (md Metadata) Copy() Metadata {
+	if md == nil {
+		return nil
+	}
+	out := make(Metadata, len(md))
+	for k, v := range md {
+		out[k] = v
+	}
+	return out
+}
+
+// 客户端发出的元数据和服务端收到的元数据使用不同的 key 保存，
+// 这样服务端在处理请求时发起的下游调用不会把收到的元数据原样转发出去。
+type (
+	outgoingMetadataKey struct{}
+	incomingMetadataKey struct{}
+	replyMetadataKey    struct{}
+	replyHeaderKey      struct{}
+)
+
+// pairs 将 k1, v1, k2, v2 ... 形式的参数合并到 md 中。
+func pairs(md Metadata, kv []string) Metadata {
+	if len(kv)%2 == 1 {
+		panic(fmt.Sprintf("rpc: got an odd number of metadata key/value pairs: %d", len(kv)))
+	}
+	for i := 0; i < len(kv); i += 2 {
+		md[kv[i]] = kv[i+1]
+	}
+	return md
+}
+
+// WithMetadata returns a copy of ctx whose outgoing metadata has the key/value pairs kv added.
+// Client.Call 会把 ctx 中的元数据放进请求的 Header 中发送给服务端。
+//
+//	ctx = minirpc.WithMetadata(ctx, "request-id", id, "tenant", tenant)
+func WithMetadata(ctx context.Context, kv ...string) context.Context {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return context.WithValue(ctx, outgoingMetadataKey{}, pairs(md.Copy().orEmpty(), kv))
+}
+
+func (md Metadata) orEmpty() Metadata {
+	if md == nil {
+		return make(Metadata)
+	}
+	return md
+}
+
+// outgoingMetadata 返回 WithMetadata 设置的元数据。
+func outgoingMetadata(ctx context.Context) Metadata {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md
+}
+
+// WithReplyMetadata returns a copy of ctx that makes Client.Call store the metadata
+// the server sent back with the response in *md.
+//
+//	var md minirpc.Metadata
+//	err := client.Call(minirpc.WithReplyMetadata(ctx, &md), "Foo.Sum", args, &reply)
+func WithReplyMetadata(ctx context.Context, md *Metadata) context.Context {
+	return context.WithValue(ctx, replyHeaderKey{}, md)
+}
+
+// MetadataFromContext returns the metadata the client sent with the request being handled.
+// 服务端在处理请求时使用。
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md
+}
+
+// replyMetadata 收集服务端处理请求时设置的响应元数据。
+type replyMetadata struct {
+	mu sync.Mutex
+	md Metadata
+}
+
+func (r *replyMetadata) get() Metadata {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.md.Copy()
+}
+
+// SetReplyMetadata adds the key/value pairs kv to the metadata sent back with the response.
+// 只能在服务端处理请求时使用，ctx 不是请求的上下文时返回错误。
+func SetReplyMetadata(ctx context.Context, kv ...string) error {
+	r, ok := ctx.Value(replyMetadataKey{}).(*replyMetadata)
+	if !ok {
+		return fmt.Errorf("rpc: SetReplyMetadata called outside of a request context")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.md = pairs(r.md.orEmpty(), kv)
+	return nil
+}
+
+// newRequestContext 创建服务端处理请求时使用的上下文，携带客户端发来的元数据，并收集响应元数据。
+func newRequestContext(ctx context.Context, md Metadata) (context.Context, *replyMetadata) {
+	reply := new(replyMetadata)
+	ctx = context.WithValue(ctx, incomingMetadataKey{}, md)
+	return context.WithValue(ctx, replyMetadataKey{}, reply), reply
+}
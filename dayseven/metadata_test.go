@@ -0,0 +1,52 @@
+package minirpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"minirpc/codec"
+)
+
+func TestWithMetadata(t *testing.T) {
+	parent := WithMetadata(context.Background(), "request-id", "1")
+	child := WithMetadata(parent, "tenant", "t1", "request-id", "2")
+	_assert(outgoingMetadata(parent)["request-id"] == "1" && outgoingMetadata(parent)["tenant"] == "",
+		"WithMetadata shouldn't modify the parent context")
+	md := outgoingMetadata(child)
+	_assert(md["request-id"] == "2" && md["tenant"] == "t1", "unexpected metadata %v", md)
+	_assert(MetadataFromContext(child) == nil, "outgoing metadata shouldn't be visible as incoming metadata")
+	_assert(SetReplyMetadata(child, "k", "v") != nil, "expect an error outside of a request context")
+}
+
+// 服务端从请求上下文中读取元数据，设置的响应元数据随响应一起返回。
+func TestServer_metadata(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	c1, c2 := net.Pipe()
+	defer func() { _ = c1.Close() }()
+	clientCC, serverCC := newFrameCodec(c1), newFrameCodec(c2)
+	clientCC.setCodec(codec.GobType, codec.NewGobCodec)
+	serverCC.setCodec(codec.GobType, codec.NewGobCodec)
+
+	go func() {
+		h := &codec.Header{ServiceMethod: "Foo.Sum", Seq: 1, Metadata: map[string]string{"request-id": "42"}}
+		_ = clientCC.Write(h, Args{Num1: 1, Num2: 2})
+	}()
+	req, err := server.readRequest(context.Background(), serverCC)
+	_assert(err == nil, "failed to read request: %v", err)
+	_assert(MetadataFromContext(req.ctx)["request-id"] == "42", "expect request metadata in the server context")
+	_assert(SetReplyMetadata(req.ctx, "served-by", "test") == nil, "failed to set reply metadata")
+
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go server.handleRequest(serverCC, req, new(sync.Mutex), wg, 0)
+	var h codec.Header
+	var reply int
+	_assert(clientCC.ReadHeader(&h) == nil && clientCC.ReadBody(&reply) == nil, "failed to read response")
+	_assert(reply == 3 && h.Metadata["served-by"] == "test" && h.Metadata["request-id"] == "",
+		"unexpected response %d %v", reply, h.Metadata)
+	wg.Wait()
+}
@@ -0,0 +1,30 @@
+package minirpc
+
+import "minirpc/codec"
+
+// Notify sends a one-way call: the server handles it but sends no response.
+// Notify 不等待服务端处理，也不注册等待响应的 Call，只返回发送请求时的错误，
+// 服务端处理出错时只记录日志。服务端可以用没有返回值的方法接收通知，
+// 这样的方法必须接受 context.Context，argType 不能是接口类型：
+//
+//	func (t *T) MethodName(ctx context.Context, argType T1) error
+//
+// 有返回值的方法同样可以通过 Notify 调用，返回值被丢弃。
+func (client *Client) Notify(serviceMethod string, args interface{}) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	client.mu.Lock()
+	if client.closing || client.shutdown {
+		client.mu.Unlock()
+		return ErrShutdown
+	}
+	if client.draining {
+		client.mu.Unlock()
+		return ErrServerShuttingDown
+	}
+	// 单向调用同样占用一个 seq，服务端据此区分不同的请求
+	seq := client.seq
+	client.seq++
+	client.mu.Unlock()
+	return client.cc.writeMessage(flagOneWay, &codec.Header{ServiceMethod: serviceMethod, Seq: seq}, args)
+}
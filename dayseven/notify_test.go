@@ -0,0 +1,63 @@
+package minirpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Inbox chan string
+
+func (in Inbox) Push(ctx context.Context, msg string) error {
+	in <- msg
+	return nil
+}
+
+func (in Inbox) Fail(ctx context.Context, msg string) error {
+	in <- "fail:" + msg
+	return context.Canceled
+}
+
+// 单向调用不需要响应，没有返回值的方法不能通过 Call 调用。
+func TestClient_Notify(t *testing.T) {
+	t.Parallel()
+	in := make(Inbox, 4)
+	server := NewServer()
+	_ = server.Register(in)
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	_assert(client.Notify("Inbox.Push", "hello") == nil, "failed to notify")
+	_assert(client.Notify("Inbox.Fail", "oops") == nil, "failed to notify")
+	_assert(client.Notify("Inbox.Missing", "x") == nil, "notify shouldn't wait for the server")
+	_assert(client.Notify("Foo.Sum", Args{1, 2}) == nil, "methods with a reply can also be notified")
+	// 请求在各自的协程中处理，到达的顺序不固定
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-in:
+			got[msg] = true
+		case <-time.After(time.Second):
+			t.Fatalf("only got notifications %v", got)
+		}
+	}
+	_assert(got["hello"] && got["fail:oops"], "unexpected notifications %v", got)
+
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "the connection should still work: %v", err)
+	err = client.Call(context.Background(), "Inbox.Push", "x", &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "has no reply"), "unexpected error: %v", err)
+	_assert(len(client.pending) == 0, "notifications shouldn't be registered as pending calls")
+
+	_ = client.Close()
+	_assert(client.Notify("Inbox.Push", "x") == ErrShutdown, "expect ErrShutdown after Close")
+}
@@ -0,0 +1,114 @@
+package minirpc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrPermissionDenied is returned for calls the server's Policy doesn't allow.
+var ErrPermissionDenied = errors.New("rpc: permission denied")
+
+// Rule 列出允许调用的 principal 名称和角色，满足其中任意一个即可，
+// Principals 中的 "*" 表示允许任何调用方，包括没有通过认证的调用方。
+type Rule struct {
+	Principals []string `json:"principals,omitempty"`
+	Roles      []string `json:"roles,omitempty"`
+}
+
+func (r Rule) allows(p *Principal) bool {
+	for _, name := range r.Principals {
+		if name == "*" || p != nil && name == p.Name {
+			return true
+		}
+	}
+	if p == nil {
+		return false
+	}
+	for _, role := range r.Roles {
+		for _, have := range p.Roles {
+			if role == have {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Policy 声明哪些调用方可以调用哪些方法，规则的 key 可以是 "Service.Method"、"Service.*" 或 "*"，
+// 查找时依次使用最具体的规则，没有匹配规则的方法不允许调用。
+//
+// 策略文件是 JSON 格式，例如：
+//
+//	{
+//		"Arith.*":   {"roles": ["dev"]},
+//		"Arith.Div": {"principals": ["alice"]},
+//		"Health.*":  {"principals": ["*"]}
+//	}
+type Policy struct {
+	mu    sync.RWMutex
+	rules map[string]Rule
+}
+
+// NewPolicy returns an empty Policy that denies every call.
+func NewPolicy() *Policy {
+	return &Policy{rules: make(map[string]Rule)}
+}
+
+// LoadPolicy reads a JSON policy from r.
+func LoadPolicy(r io.Reader) (*Policy, error) {
+	p := NewPolicy()
+	if err := json.NewDecoder(r).Decode(&p.rules); err != nil {
+		return nil, fmt.Errorf("rpc: invalid policy: %v", err)
+	}
+	// JSON 的 null 会把 rules 置为 nil，之后的 Allow 会 panic
+	if p.rules == nil {
+		return nil, errors.New("rpc: invalid policy: expect a JSON object")
+	}
+	return p, nil
+}
+
+// LoadPolicyFile reads a JSON policy from the named file.
+func LoadPolicyFile(name string) (*Policy, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return LoadPolicy(f)
+}
+
+// Allow sets the rule for pattern, replacing any previous rule.
+func (p *Policy) Allow(pattern string, rule Rule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.rules[pattern] = rule
+}
+
+// Authorize returns ErrPermissionDenied if principal may not call serviceMethod.
+// principal 为 nil 表示没有通过认证的调用方。
+func (p *Policy) Authorize(principal *Principal, serviceMethod string) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	rule, ok := p.rules[serviceMethod]
+	if !ok {
+		if dot := strings.LastIndex(serviceMethod, "."); dot >= 0 {
+			rule, ok = p.rules[serviceMethod[:dot]+".*"]
+		}
+	}
+	if !ok {
+		rule, ok = p.rules["*"]
+	}
+	if ok && rule.allows(principal) {
+		return nil
+	}
+	name := "anonymous"
+	if principal != nil {
+		name = principal.Name
+	}
+	return fmt.Errorf("%w: %s may not call %s", ErrPermissionDenied, name, serviceMethod)
+}
@@ -0,0 +1,78 @@
+package minirpc
+
+import (
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPolicy_Authorize(t *testing.T) {
+	p, err := LoadPolicy(strings.NewReader(`{
+		"Arith.*":   {"roles": ["dev"]},
+		"Arith.Div": {"principals": ["alice"]},
+		"Health.*":  {"principals": ["*"]}
+	}`))
+	_assert(err == nil, "failed to load policy: %v", err)
+	alice := &Principal{Name: "alice"}
+	bob := &Principal{Name: "bob", Roles: []string{"dev"}}
+	cases := []struct {
+		principal     *Principal
+		serviceMethod string
+		allowed       bool
+	}{
+		{bob, "Arith.Sum", true},
+		{alice, "Arith.Sum", false},
+		{alice, "Arith.Div", true},
+		{bob, "Arith.Div", false},
+		{nil, "Health.Check", true},
+		{nil, "Arith.Sum", false},
+		{bob, "Other.Method", false},
+	}
+	for _, c := range cases {
+		err := p.Authorize(c.principal, c.serviceMethod)
+		_assert((err == nil) == c.allowed, "%v calling %s: %v", c.principal, c.serviceMethod, err)
+		_assert(err == nil || errors.Is(err, ErrPermissionDenied), "expect ErrPermissionDenied, got %v", err)
+	}
+	p.Allow("*", Rule{Roles: []string{"dev"}})
+	_assert(p.Authorize(bob, "Other.Method") == nil, "the * rule should allow bob")
+
+	_, err = LoadPolicy(strings.NewReader("null"))
+	_assert(err != nil, "expect a null policy to be rejected")
+	p, err = LoadPolicy(strings.NewReader("{}"))
+	_assert(err == nil && p.Authorize(bob, "Arith.Sum") != nil, "an empty policy should deny every call: %v", err)
+	p.Allow("*", Rule{Principals: []string{"*"}})
+}
+
+// 没有权限的调用得到 ErrPermissionDenied，并在调试页面上按方法统计。
+func TestServer_Policy(t *testing.T) {
+	t.Parallel()
+	server := NewServer()
+	server.Authenticator = NewTokenAuthenticator(map[string]*Principal{
+		"alice": {Name: "alice", Roles: []string{"dev"}},
+		"bob":   {Name: "bob"},
+	})
+	server.Policy = NewPolicy()
+	server.Policy.Allow("Me.*", Rule{Roles: []string{"dev"}})
+	var m Me
+	_ = server.Register(&m)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+	addr := l.Addr().String()
+
+	name, err := callMe(addr, TokenCredentials("alice"))
+	_assert(err == nil && name == "alice/dev", "expect alice/dev, got %q (%v)", name, err)
+	_, err = callMe(addr, TokenCredentials("bob"))
+	_assert(errors.Is(err, ErrPermissionDenied) && strings.Contains(err.Error(), "bob may not call Me.Name"),
+		"expect ErrPermissionDenied, got %v", err)
+
+	svci, _ := server.serviceMap.Load("Me")
+	mtype := svci.(*service).method["Name"]
+	_assert(mtype.NumDenied() == 1 && mtype.NumCalls() == 1, "expect 1 call and 1 denial, got %d and %d",
+		mtype.NumCalls(), mtype.NumDenied())
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugPath, nil))
+	_assert(strings.Contains(w.Body.String(), "Denied"), "the debug page should show denials")
+}
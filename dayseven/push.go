@@ -0,0 +1,91 @@
+package minirpc
+
+import (
+	"context"
+	"log"
+	"minirpc/codec"
+	"sync/atomic"
+)
+
+// 服务端可以在任意时刻向一个已经连接的客户端推送事件，例如缓存失效通知和任务完成通知。
+// 推送帧带有 flagPush，seq 为 0，Header.ServiceMethod 是事件名，
+// Client.receive 先根据帧头区分推送和调用的响应，因此不会影响 seq 的匹配。
+
+// pushQueueLen 是客户端还没有处理的推送的上限，超过时新的推送被丢弃，连接仍然继续读取。
+const pushQueueLen = 1024
+
+// Pusher sends events to the client of a connection.
+// 服务方法通过 PusherFromContext 得到调用方连接的 Pusher，可以保存下来，在方法返回之后继续推送。
+type Pusher struct {
+	sc *serverConn
+}
+
+type pusherKey struct{}
+
+// PusherFromContext returns the Pusher of the connection the request came from.
+func PusherFromContext(ctx context.Context) (*Pusher, bool) {
+	p, ok := ctx.Value(pusherKey{}).(*Pusher)
+	return p, ok
+}
+
+// Push sends event with body v to the client.
+// 连接已经断开时返回错误，客户端没有注册 event 的处理函数时推送被丢弃。
+func (p *Pusher) Push(event string, v interface{}) error {
+	p.sc.sending.Lock()
+	defer p.sc.sending.Unlock()
+	return p.sc.cc.writeMessage(flagPush, &codec.Header{ServiceMethod: event}, v)
+}
+
+// Push is an event pushed by the server.
+type Push struct {
+	Event string
+	body  codec.Codec
+}
+
+// Decode decodes the body of the push into v.
+func (p *Push) Decode(v interface{}) error {
+	if p.body == nil {
+		return nil
+	}
+	return p.body.ReadBody(v)
+}
+
+// PushHandler handles the events pushed by the server.
+type PushHandler func(p *Push)
+
+// Handle registers the handler for event, replacing any previous handler.
+// 处理函数在同一个协程中按照推送的顺序依次调用，可以在其中发起调用；
+// 推送在客户端排队时不会暂停读取连接，处理得慢时最多积压 pushQueueLen 个推送，之后的推送被丢弃。
+func (client *Client) Handle(event string, handler PushHandler) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if handler == nil {
+		delete(client.handlers, event)
+		return
+	}
+	client.handlers[event] = handler
+}
+
+// queuePush 把推送交给 dispatchPushes，队列已满时丢弃它。
+// receive 从不等待处理函数，否则推送积压时处理函数中发起的调用永远等不到响应。
+func (client *Client) queuePush(p *Push) {
+	select {
+	case client.pushes <- p:
+	default:
+		atomic.AddUint64(&client.droppedPushes, 1)
+		log.Println("rpc client: push queue is full, dropping event", p.Event)
+	}
+}
+
+func (client *Client) dispatchPushes() {
+	for p := range client.pushes {
+		client.mu.Lock()
+		handler := client.handlers[p.Event]
+		client.mu.Unlock()
+		if handler == nil {
+			log.Println("rpc client: no handler for push event", p.Event)
+			continue
+		}
+		handler(p)
+	}
+}
@@ -0,0 +1,182 @@
+package minirpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Jobs struct {
+	pushers chan *Pusher
+}
+
+// Start 立即返回，任务完成后通过调用方连接的 Pusher 推送 "job.done"。
+func (j *Jobs) Start(ctx context.Context, id int, reply *bool) error {
+	p, ok := PusherFromContext(ctx)
+	if !ok {
+		return errors.New("no pusher")
+	}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = p.Push("job.done", id)
+		j.pushers <- p
+	}()
+	*reply = true
+	return nil
+}
+
+func (j *Jobs) Status(id int, reply *string) error {
+	*reply = "done"
+	return nil
+}
+
+// 推送交给 Handle 注册的处理函数，处理函数中可以发起调用，之后的调用不受推送影响。
+func TestClient_Handle(t *testing.T) {
+	t.Parallel()
+	jobs := &Jobs{pushers: make(chan *Pusher, 2)}
+	server := NewServer()
+	_ = server.Register(jobs)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	done := make(chan string, 2)
+	client.Handle("job.done", func(p *Push) {
+		var id int
+		if err := p.Decode(&id); err != nil {
+			done <- err.Error()
+			return
+		}
+		var status string
+		if err := client.Call(context.Background(), "Jobs.Status", id, &status); err != nil {
+			status = err.Error()
+		}
+		done <- status
+	})
+	for i := 1; i <= 2; i++ {
+		var ok bool
+		err = client.Call(context.Background(), "Jobs.Start", i, &ok)
+		_assert(err == nil && ok, "failed to start job %d: %v", i, err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case status := <-done:
+			_assert(status == "done", "unexpected status %q", status)
+		case <-time.After(time.Second):
+			t.Fatal("the push didn't arrive")
+		}
+	}
+
+	// 客户端断开之后推送失败
+	p := <-jobs.pushers
+	_ = client.Close()
+	deadline := time.Now().Add(time.Second)
+	for p.Push("job.done", 3) == nil {
+		_assert(time.Now().Before(deadline), "expect Push to fail after the client closed")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type Flooder struct {
+	flooded chan struct{}
+}
+
+// Flood 连续推送 n 个事件之后返回。
+func (f *Flooder) Flood(ctx context.Context, n int, reply *bool) error {
+	p, _ := PusherFromContext(ctx)
+	for i := 0; i < n; i++ {
+		if err := p.Push("flood", i); err != nil {
+			return err
+		}
+	}
+	close(f.flooded)
+	*reply = true
+	return nil
+}
+
+func (f *Flooder) Ping(n int, reply *int) error {
+	*reply = n
+	return nil
+}
+
+// 推送大量积压时，处理函数中发起的调用仍然能收到排在这些推送之后的响应。
+func TestClient_HandleBacklog(t *testing.T) {
+	t.Parallel()
+	const n = 500
+	f := &Flooder{flooded: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(f)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	handled := make(chan error, n)
+	client.Handle("flood", func(p *Push) {
+		// 所有推送都已经发出之后才发起调用，响应排在它们后面
+		<-f.flooded
+		var i, reply int
+		_ = p.Decode(&i)
+		handled <- client.Call(context.Background(), "Flooder.Ping", i, &reply)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var ok bool
+	err = client.Call(ctx, "Flooder.Flood", n, &ok)
+	_assert(err == nil && ok, "failed to flood: %v", err)
+	for i := 0; i < n; i++ {
+		select {
+		case err := <-handled:
+			_assert(err == nil, "the call in the handler failed: %v", err)
+		case <-ctx.Done():
+			t.Fatalf("only %d of %d pushes were handled", i, n)
+		}
+	}
+}
+
+// 推送超过 pushQueueLen 之后被丢弃，连接仍然继续读取，调用不受影响。
+func TestClient_HandleOverflow(t *testing.T) {
+	t.Parallel()
+	const n = pushQueueLen + 200
+	f := &Flooder{flooded: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(f)
+	l, _ := net.Listen("tcp", ":0")
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// Flood 的响应排在所有推送之后，收到响应之前处理函数不取走任何推送
+	handled, release := make(chan struct{}, n), make(chan struct{})
+	client.Handle("flood", func(p *Push) {
+		<-release
+		handled <- struct{}{}
+	})
+	var ok bool
+	err = client.Call(context.Background(), "Flooder.Flood", n, &ok)
+	_assert(err == nil && ok, "failed to flood: %v", err)
+	close(release)
+	var reply int
+	err = client.Call(context.Background(), "Flooder.Ping", 1, &reply)
+	_assert(err == nil && reply == 1, "calls should still work after dropping pushes: %v", err)
+
+	dropped := int(atomic.LoadUint64(&client.droppedPushes))
+	_assert(dropped >= n-pushQueueLen-1, "expect the pushes over the queue to be dropped, dropped %d", dropped)
+	for i := 0; i < n-dropped; i++ {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of %d queued pushes were handled", i, n-dropped)
+		}
+	}
+}
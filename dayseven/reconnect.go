@@ -0,0 +1,288 @@
+package minirpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ConnState is the state of a ReconnectingClient's connection.
+type ConnState int
+
+const (
+	Connecting       ConnState = iota // 正在建立连接并握手
+	Ready                             // 连接可用
+	TransientFailure                  // 连接断开或建立失败，等待退避之后重试
+	Shutdown                          // 已经调用了 Close，不会再重连
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case Connecting:
+		return "connecting"
+	case Ready:
+		return "ready"
+	case TransientFailure:
+		return "transient-failure"
+	case Shutdown:
+		return "shutdown"
+	}
+	return fmt.Sprintf("ConnState(%d)", int(s))
+}
+
+// Backoff configures the delay between reconnection attempts.
+// 第 n 次重试之前等待 BaseDelay*Multiplier^n，不超过 MaxDelay，
+// 再在 [-Jitter, +Jitter] 的比例内随机浮动，避免大量客户端同时重连。
+type Backoff struct {
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// DefaultBackoff is used when Option.Backoff is the zero value.
+var DefaultBackoff = Backoff{
+	BaseDelay:  100 * time.Millisecond,
+	MaxDelay:   10 * time.Second,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+}
+
+// Delay returns how long to wait before the given retry, counting from 0.
+func (b Backoff) Delay(retries int) time.Duration {
+	if b == (Backoff{}) {
+		b = DefaultBackoff
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = 1
+	}
+	d := float64(b.BaseDelay) * math.Pow(b.Multiplier, float64(retries))
+	if b.MaxDelay > 0 && d > float64(b.MaxDelay) {
+		d = float64(b.MaxDelay)
+	}
+	d *= 1 + b.Jitter*(rand.Float64()*2-1)
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// ReconnectingClient is a Client that redials the same address whenever the connection is lost.
+// 连接断开时正在等待响应的调用返回包装了 ErrUnavailable 的错误，由调用方决定是否重试；
+// 新的调用在 Connecting 状态下等待连接建立，在 TransientFailure 状态下立即返回 ErrUnavailable。
+// 每次重连都会重新握手（包括认证），Handle 注册的推送处理函数会被带到新的连接上。
+type ReconnectingClient struct {
+	rpcAddr string
+	opt     *Option
+
+	mu       sync.Mutex // protect following
+	client   *Client    // 当前的连接，只在 Ready 状态下不为 nil
+	state    ConnState
+	lastErr  error                  // 最近一次连接失败的原因
+	changed  chan struct{}          // 状态变化时关闭并替换，用来唤醒等待的调用
+	watchers []chan ConnState       // Watch 返回的通道
+	handlers map[string]PushHandler // 需要在每个新连接上注册的推送处理函数
+	closed   chan struct{}
+}
+
+// NewReconnectingClient returns a client for rpcAddr (in XDial's protocol@addr format)
+// and starts connecting in the background.
+func NewReconnectingClient(rpcAddr string, opts ...*Option) (*ReconnectingClient, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	rc := &ReconnectingClient{
+		rpcAddr:  rpcAddr,
+		opt:      opt,
+		state:    Connecting,
+		changed:  make(chan struct{}),
+		handlers: make(map[string]PushHandler),
+		closed:   make(chan struct{}),
+	}
+	go rc.run()
+	return rc, nil
+}
+
+// run 负责建立连接，连接断开之后按照退避策略重连，直到 Close 被调用。
+func (rc *ReconnectingClient) run() {
+	backoff := rc.opt.Backoff
+	for retries := 0; ; retries++ {
+		if !rc.setState(Connecting, nil) {
+			return
+		}
+		client, err := XDial(rc.rpcAddr, rc.opt)
+		if err == nil {
+			if !rc.connected(client) {
+				_ = client.Close()
+				return
+			}
+			retries = 0
+			select {
+			case <-client.done:
+				err = errors.New("connection lost")
+			case <-rc.closed:
+				return
+			}
+		}
+		if !rc.setState(TransientFailure, err) {
+			return
+		}
+		timer := time.NewTimer(backoff.Delay(retries))
+		select {
+		case <-timer.C:
+		case <-rc.closed:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// connected 切换到新建立的连接，已经关闭时返回 false。
+func (rc *ReconnectingClient) connected(client *Client) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.state == Shutdown {
+		return false
+	}
+	for event, h := range rc.handlers {
+		client.Handle(event, h)
+	}
+	rc.client = client
+	rc.transitionLocked(Ready)
+	return true
+}
+
+// setState 切换到 Connecting 或 TransientFailure 并丢弃当前的连接，已经关闭时返回 false。
+func (rc *ReconnectingClient) setState(state ConnState, err error) bool {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.state == Shutdown {
+		return false
+	}
+	if err != nil {
+		rc.lastErr = err
+	}
+	rc.client = nil
+	rc.transitionLocked(state)
+	return true
+}
+
+// transitionLocked 记录新的状态并通知所有等待者，调用方持有 rc.mu。
+// 每个 Watch 通道只缓存最新的状态，接收慢的一方可能错过中间状态，但总能看到最后的状态。
+func (rc *ReconnectingClient) transitionLocked(state ConnState) {
+	if rc.state == state {
+		return
+	}
+	rc.state = state
+	close(rc.changed)
+	rc.changed = make(chan struct{})
+	for _, ch := range rc.watchers {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- state
+	}
+}
+
+// State returns the current connection state.
+func (rc *ReconnectingClient) State() ConnState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.state
+}
+
+// Watch returns a channel that receives state transitions from now on.
+// 通道只缓存最新的状态，接收慢时会错过中间状态；送出 Shutdown 之后通道被关闭。
+func (rc *ReconnectingClient) Watch() <-chan ConnState {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	ch := make(chan ConnState, 1)
+	if rc.state == Shutdown {
+		ch <- Shutdown
+		close(ch)
+		return ch
+	}
+	rc.watchers = append(rc.watchers, ch)
+	return ch
+}
+
+// current 返回可用的连接，Connecting 状态下等待连接建立或 ctx 结束。
+func (rc *ReconnectingClient) current(ctx context.Context) (*Client, error) {
+	for {
+		rc.mu.Lock()
+		state, client, changed, lastErr := rc.state, rc.client, rc.changed, rc.lastErr
+		rc.mu.Unlock()
+		switch state {
+		case Ready:
+			return client, nil
+		case Shutdown:
+			return nil, ErrShutdown
+		case TransientFailure:
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, lastErr)
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, errors.New("rpc client: call failed:" + ctx.Err().Error())
+		}
+	}
+}
+
+// Call invokes the named function on the current connection.
+func (rc *ReconnectingClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	client, err := rc.current(ctx)
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, serviceMethod, args, reply)
+}
+
+// Notify sends a one-way call on the current connection.
+func (rc *ReconnectingClient) Notify(serviceMethod string, args interface{}) error {
+	client, err := rc.current(context.Background())
+	if err != nil {
+		return err
+	}
+	return client.Notify(serviceMethod, args)
+}
+
+// Handle registers the handler for pushes of the given event on every connection.
+func (rc *ReconnectingClient) Handle(event string, handler PushHandler) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if handler == nil {
+		delete(rc.handlers, event)
+	} else {
+		rc.handlers[event] = handler
+	}
+	if rc.client != nil {
+		rc.client.Handle(event, handler)
+	}
+}
+
+// Close stops reconnecting and closes the current connection.
+func (rc *ReconnectingClient) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.state == Shutdown {
+		return ErrShutdown
+	}
+	close(rc.closed)
+	client := rc.client
+	rc.client = nil
+	rc.transitionLocked(Shutdown)
+	for _, ch := range rc.watchers {
+		close(ch)
+	}
+	rc.watchers = nil
+	if client != nil {
+		return client.Close()
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package minirpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond, Multiplier: 2}
+	_assert(b.Delay(0) == 10*time.Millisecond, "unexpected first delay %v", b.Delay(0))
+	_assert(b.Delay(2) == 40*time.Millisecond, "unexpected third delay %v", b.Delay(2))
+	_assert(b.Delay(10) == 100*time.Millisecond, "delay should be capped, got %v", b.Delay(10))
+	b.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := b.Delay(1)
+		_assert(d >= 10*time.Millisecond && d <= 30*time.Millisecond, "jittered delay out of range: %v", d)
+	}
+}
+
+// waitState 从 Watch 通道中等待指定的状态。
+func waitState(t *testing.T, ch <-chan ConnState, want ConnState) {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case s, ok := <-ch:
+			if !ok {
+				t.Fatalf("watch channel closed before %s", want)
+			}
+			if s == want {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for state %s", want)
+		}
+	}
+}
+
+// 服务端重启之后客户端自动重连，断开时等待中的调用得到可以重试的 ErrUnavailable。
+func TestReconnectingClient(t *testing.T) {
+	t.Parallel()
+	server, addr := startSleeperServer()
+	opt := &Option{Backoff: Backoff{BaseDelay: 20 * time.Millisecond, MaxDelay: 50 * time.Millisecond, Multiplier: 2}}
+	rc, err := NewReconnectingClient("tcp@"+addr, opt)
+	_assert(err == nil, "failed to create client: %v", err)
+	watch := rc.Watch()
+
+	var reply int
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// Connecting 状态下的调用等待连接建立
+	err = rc.Call(ctx, "Sleeper.Sleep", 1, &reply)
+	_assert(err == nil && reply == 1, "call failed: %v", err)
+	_assert(rc.State() == Ready, "expect ready, got %s", rc.State())
+
+	done := make(chan error, 1)
+	go func() { done <- rc.Call(context.Background(), "Sleeper.Sleep", 2000, &reply) }()
+	time.Sleep(50 * time.Millisecond)
+	_ = server.Close()
+	select {
+	case err = <-done:
+		_assert(errors.Is(err, ErrUnavailable), "expect ErrUnavailable, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("the pending call wasn't terminated")
+	}
+	waitState(t, watch, TransientFailure)
+	err = rc.Call(context.Background(), "Sleeper.Sleep", 1, &reply)
+	_assert(errors.Is(err, ErrUnavailable), "expect ErrUnavailable while disconnected, got %v", err)
+
+	// 在同一个地址上重新启动服务端
+	server = NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	l, err := net.Listen("tcp", addr)
+	_assert(err == nil, "failed to listen again: %v", err)
+	go server.Accept(l)
+	defer func() { _ = server.Close() }()
+
+	waitState(t, watch, Ready)
+	err = rc.Call(context.Background(), "Sleeper.Sleep", 3, &reply)
+	_assert(err == nil && reply == 3, "call after reconnecting failed: %v", err)
+
+	_assert(rc.Close() == nil, "Close failed")
+	waitState(t, watch, Shutdown)
+	_, ok := <-watch
+	_assert(!ok, "watch channel should be closed after shutdown")
+	_assert(rc.Call(context.Background(), "Sleeper.Sleep", 1, &reply) == ErrShutdown, "expect ErrShutdown after Close")
+}
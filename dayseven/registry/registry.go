@@ -51,7 +51,7 @@ func (r *MiniRegistry) putServer(addr string)  {
 	}
 }
 
-func (r MiniRegistry) aliveServers() []string  {
+func (r *MiniRegistry) aliveServers() []string  {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	var alive []string
@@ -1,6 +1,8 @@
 package minirpc
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,31 +14,73 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const MagicNumber =0x3bef5c
-// HTTP 报文，分为 header 和 body 2 部分，
-//body 的格式和长度通过 header 中的 Content-Type 和 Content-Length 指定，
-//服务端通过解析 header 就能够知道如何从 body 中读取需要的信息。
-//| Option{MagicNumber: xxx, CodecType: xxx}
-//| <-------   编码方式由 CodeType 决定   ------->|
-//| Header{ServiceMethod ...} | Body interface{} |
-//| <------      固定 JSON 编码      ------>
+// 协议版本 2 中，连接上的每一条消息都是一个定长帧头加载荷的帧（见 frame.go），
+// 连接建立后客户端首先发送握手帧，服务端确认后才开始收发请求：
+//| Frame{flags: handshake} Option | Frame{flags: handshake} Option |
+//| <---   载荷固定 JSON 编码   --->| <---   服务端确认的 Option  --->|
+//| Frame{flags: auth} ... | Frame{flags: handshake|auth} |  服务端要求认证时交换认证帧（见 auth.go）
+//| Frame{seq} Header{ServiceMethod ...} Body interface{} | ...
+//|            <------ 编码方式由 CodeType 决定 ------>   |
 
 type Option struct {
-	MagicNumber int  // MagicNumber marks this's a minirpc request
+	MagicNumber int  // MagicNumber marks this's a minirpc request, it is also carried in every frame header
 	CodecType codec.Type // client may choose different Codec to encode body
 	//为了实现上的简单，将超时设定放在了 Option 中。
 	//ConnectTimeout 默认值为 10s，HandleTimeout 默认值为 0，即不设限。
 	ConnectTimeout time.Duration
 	HandleTimeout	time.Duration
+	//Compression 是消息体的压缩算法，在握手时协商，服务端不支持时退回 CompressNone。
+	//CompressThreshold 是压缩的最小消息长度，为 0 时使用 DefaultCompressThreshold。
+	Compression Compression
+	CompressThreshold int
+	//Interceptors 包装该连接上的每一次 Client.Call，按顺序执行，不参与握手。
+	Interceptors []ClientInterceptor `json:"-"`
+	//TLSConfig 是 XDial 使用 tls@addr 连接时的 TLS 配置，不参与握手。
+	TLSConfig *tls.Config `json:"-"`
+	//Credentials 是客户端的认证凭证，握手时只发送它的认证方式 AuthScheme。
+	Credentials Credentials `json:"-"`
+	AuthScheme string `json:",omitempty"`
+	//StreamWindow 是流的初始窗口，即接收方确认之前发送方最多可以发送的消息数，为 0 时使用 DefaultStreamWindow。
+	StreamWindow int
+	//Backoff 是 ReconnectingClient 重新建立连接时的退避策略，为零值时使用 DefaultBackoff，不参与握手。
+	Backoff Backoff `json:"-"`
 }
 
 type Server struct {
+	//OnPanic 在服务方法发生 panic 时被调用，可以用来上报错误，为 nil 时只打印日志。
+	OnPanic func(err *PanicError)
+	//SendPanicStack 为 true 时，发生 panic 的调用栈会随错误信息一起返回给客户端。
+	SendPanicStack bool
+	//Authenticator 不为 nil 时，客户端在握手之后必须通过认证才能发起调用。
+	Authenticator Authenticator
+	//Policy 不为 nil 时，每次调用之前检查调用方是否有权限调用该方法。
+	Policy *Policy
+
 	serviceMap sync.Map
+	compress compressStats // 所有连接上的消息压缩统计
+
+	mu sync.Mutex // protect following
+	listeners map[net.Listener]struct{}
+	conns map[*serverConn]struct{}
+	shuttingDown bool // 已经调用了 Shutdown 或 Close
+	interceptors []Interceptor // Use 注册的拦截器
+	svcInterceptors map[string][]Interceptor // UseService 注册的拦截器
+	handlers sync.WaitGroup // 所有连接上正在处理的请求，Shutdown 等待它们完成
 }
 
+// ErrServerShuttingDown is returned for calls made after the server has begun shutting down.
+// 此时请求没有被处理，可以换一个服务实例重试。
+var ErrServerShuttingDown = errors.New("rpc: server is shutting down")
+
+// ErrHandleTimeout is returned for calls that exceeded the server's HandleTimeout.
+// 方法可能仍在执行，只有幂等的调用才适合重试。
+var ErrHandleTimeout = errors.New("rpc server: request handle timeout")
+
 var DefaultOption = &Option{
 	MagicNumber: MagicNumber,
 	CodecType:   codec.GobType,
@@ -55,58 +99,241 @@ func NewServer() *Server {
 // DefaultServer is the default instance of *Server.
 var DefaultServer = NewServer()
 //后续的 header 和 body 的编码方式由 Option 中的 CodeType 指定，
-//服务端首先使用 JSON 解码 Option，然后通过 Option 的 CodeType 解码剩余的内容。
+//服务端首先读取握手帧，解码出 Option，然后通过 Option 的 CodeType 解码后续帧中的内容。
 
 func (server *Server) ServeConn(conn io.ReadWriteCloser)  {
 	defer func() {
 		_=conn.Close()
 	}()
-	var opt Option
-	if err:=json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server:options error: ",err)
+	sc := &serverConn{cc: newFrameCodec(conn), sending: new(sync.Mutex)}
+	if !server.trackConn(sc, true) {
+		return
+	}
+	defer server.trackConn(sc, false)
+	opt, err := server.handshake(sc.cc)
+	if err != nil {
+		log.Println("rpc server:handshake error:", err)
 		return
 	}
-	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server:invalid codec number %x",opt.CodecType)
+	ctx, cancel := newConnContext(conn)
+	defer cancel()
+	if ctx, err = server.authenticate(ctx, sc.cc); err != nil {
+		log.Println("rpc server:authentication error:", err)
 		return
 	}
-	f:=codec.NewCodecFuncMap[opt.CodecType]
-	if f == nil {
-		log.Printf("rpc server:invalid codec type %s",opt.CodecType)
+	if !server.connReady(sc) {
 		return
 	}
-	server.serveCodec(f(conn),&opt)
+	ctx = context.WithValue(ctx, pusherKey{}, &Pusher{sc: sc})
+	server.serveCodec(ctx, sc, opt)
+}
+
+// handshake 读取客户端的握手帧并回复确认，握手失败时发送错误帧，让客户端得到明确的错误信息。
+func (server *Server) handshake(cc *frameCodec) (*Option, error) {
+	payload, err := cc.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	var opt Option
+	err = func() error {
+		if cc.frame.Version != ProtocolVersion {
+			return fmt.Errorf("unsupported protocol version %d", cc.frame.Version)
+		}
+		if cc.frame.Flags&flagHandshake == 0 {
+			return errors.New("expect a handshake frame")
+		}
+		if err := json.Unmarshal(payload, &opt); err != nil {
+			return fmt.Errorf("options error: %v", err)
+		}
+		f, ok := codec.Lookup(opt.CodecType)
+		if !ok {
+			return fmt.Errorf("invalid codec type %s", opt.CodecType)
+		}
+		if id := codecIDs[opt.CodecType]; cc.frame.Codec != id {
+			return fmt.Errorf("codec id %d doesn't match codec type %s", cc.frame.Codec, opt.CodecType)
+		}
+		cc.setCodec(opt.CodecType, f)
+		if _, ok := compressors[opt.Compression]; !ok {
+			opt.Compression = CompressNone
+		}
+		// 确认的 AuthScheme 为空表示不需要认证
+		if server.Authenticator == nil {
+			opt.AuthScheme = ""
+		} else if scheme := server.Authenticator.Scheme(); opt.AuthScheme != scheme {
+			return fmt.Errorf("authentication required: expect scheme %q, got %q", scheme, opt.AuthScheme)
+		}
+		return nil
+	}()
+	if err != nil {
+		_ = cc.writeFrame(flagHandshake|flagError, 0, []byte("rpc server: "+err.Error()))
+		return nil, err
+	}
+	reply, _ := json.Marshal(&opt)
+	if err := cc.writeFrame(flagHandshake, 0, reply); err != nil {
+		return nil, err
+	}
+	// 握手帧本身不压缩，确认之后的帧才使用协商出的压缩算法
+	cc.setCompression(opt.Compression, opt.CompressThreshold)
+	cc.stats = &server.compress
+	return &opt, nil
 }
 // invalidRequest is a placeholder for response argv when error occurs
 var invalidRequest = struct {}{}
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
-	sending :=new(sync.Mutex)
+//ctx 是连接级别的上下文，连接断开后被取消，每个请求的上下文都由它派生。
+func (server *Server) serveCodec(ctx context.Context, sc *serverConn, opt *Option) {
+	cc, sending := sc.cc, sc.sending
 	wg:=new(sync.WaitGroup)
+	pending := &pendingRequests{m: make(map[uint64]*request)}
+	//连接断开后取消所有请求的上下文，并等待正在处理的请求结束
+	ctx, cancel := context.WithCancel(ctx)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
 	for  {
-		req,err:=server.readRequest(cc)
+		req,err:=server.readRequest(ctx, cc)
 		if err != nil {
 			if req ==nil{
 				break
 			}
+			if req.cancel != nil {
+				req.cancel()
+			}
+			if req.oneWay {
+				log.Println("rpc server: notification error:", err)
+				continue
+			}
 			req.h.Error = err.Error()
+			req.h.Metadata = nil
 			server.sendResponse(cc,req.h, invalidRequest,sending)
 			continue
 		}
+		if cc.frame.Flags&flagCancel != 0 {
+			//客户端不再需要这个请求的结果，取消它的上下文，handleRequest 不会再发送响应
+			pending.cancel(req.h.Seq)
+			continue
+		}
+		if cc.frame.Flags&flagWindow != 0 {
+			//客户端处理完了一部分消息，流可以继续发送
+			pending.grant(req.h.Seq, windowIncrement(cc.ctrl))
+			continue
+		}
+		if cc.frame.Flags&flagStream != 0 && req.h.ServiceMethod == "" {
+			//客户端在已经打开的流上发送的消息，或者结束发送
+			pending.deliver(req.h.Seq, cc.takeBody(), cc.frame.Flags&flagEndStream != 0)
+			continue
+		}
+		if !server.beginRequest() {
+			//服务端正在关闭，客户端在收到通知之前发出的请求不再处理
+			req.cancel()
+			if req.oneWay {
+				continue
+			}
+			req.h.Error = ErrServerShuttingDown.Error()
+			req.h.Metadata = nil
+			server.sendResponse(cc,req.h,invalidRequest,sending)
+			continue
+		}
+		if req.mtype.stream {
+			//打开流的帧带有 flagEndStream 时，客户端不会在流上发送消息
+			req.stream = newServerStream(req, cc, sending, opt.StreamWindow, cc.frame.Flags&flagEndStream != 0)
+			req.replyv = reflect.ValueOf(req.stream)
+		}
+		pending.add(req)
 		wg.Add(1)
-		go server.handleRequest(cc,req,sending,wg,opt.HandleTimeout)
+		go func() {
+			defer server.handlers.Done()
+			server.handleRequest(cc,req,sending,wg,opt.HandleTimeout)
+			pending.remove(req)
+		}()
+	}
+}
+
+// pendingRequests 记录一个连接上正在处理的请求，收到取消帧时据此取消对应请求的上下文。
+type pendingRequests struct {
+	mu sync.Mutex
+	m  map[uint64]*request
+}
+
+func (p *pendingRequests) add(req *request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.m[req.seq] = req
+}
+
+func (p *pendingRequests) remove(req *request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.m[req.seq] == req {
+		delete(p.m, req.seq)
+	}
+}
+
+func (p *pendingRequests) cancel(seq uint64) {
+	p.mu.Lock()
+	req := p.m[seq]
+	delete(p.m, seq)
+	p.mu.Unlock()
+	if req != nil {
+		atomic.StoreInt32(&req.canceled, 1)
+		req.cancel()
+	}
+}
+
+func (p *pendingRequests) grant(seq uint64, n int) {
+	p.mu.Lock()
+	req := p.m[seq]
+	p.mu.Unlock()
+	if req != nil && req.stream != nil {
+		req.stream.out.grant(n)
+	}
+}
+
+func (p *pendingRequests) deliver(seq uint64, body codec.Codec, end bool) {
+	p.mu.Lock()
+	req := p.m[seq]
+	p.mu.Unlock()
+	if req == nil || req.stream == nil {
+		//流已经结束，丢弃之后到达的消息
+		return
 	}
+	if end {
+		req.stream.in.finish(io.EOF)
+		return
+	}
+	if req.stream.in.push(body) {
+		//客户端不遵守流控，方法的 Send 和 Recv 都返回错误，方法返回之后流以该错误结束
+		req.stream.out.close(errWindowExceeded)
+	}
+}
+
+func (p *pendingRequests) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.m)
 }
 
 type request struct {
 	h *codec.Header
+	seq uint64 // 请求的序号，h 在发送响应时会被修改，这里单独保存一份
 	argv,replyv reflect.Value
 	mtype *methodType
 	svc *service
+	ctx context.Context // 请求的上下文，携带客户端发来的元数据和截止时间
+	cancel context.CancelFunc
+	canceled int32 // 客户端发送了取消帧，不再需要响应
+	replyMd *replyMetadata // 处理请求时设置的响应元数据
+	stream *ServerStream // 流式方法的响应通过它发送
+	oneWay bool // 客户端通过 Notify 发起的调用，不发送响应
 }
 
-func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header,error) {
+func (server *Server) readRequestHeader(cc *frameCodec) (*codec.Header,error) {
 	var h codec.Header
 	if err:=cc.ReadHeader(&h); err != nil {
+		if errors.Is(err, errMalformedFrame) {
+			// 帧已经被完整跳过，连接仍然可用，根据帧头中的 seq 回复错误即可
+			return &h, err
+		}
 		if err!=io.EOF && err!=io.ErrUnexpectedEOF{
 			log.Println("rpc server:read header error:",err)
 		}
@@ -115,20 +342,63 @@ func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header,error) {
 	return &h,nil
 }
 
-func (server *Server) readRequest(cc codec.Codec) (*request,error) {
+func (server *Server) readRequest(ctx context.Context, cc *frameCodec) (*request,error) {
 	h,err:=server.readRequestHeader(cc)
 	if err != nil {
-		return nil,err
+		if h == nil {
+			return nil,err
+		}
+		return &request{h: h, seq: h.Seq, oneWay: cc.frame.Flags&flagOneWay != 0},err
 	}
-	req:=&request{h: h}
+	req:=&request{h: h, seq: h.Seq, oneWay: cc.frame.Flags&flagOneWay != 0}
+	if cc.frame.Flags&controlFlags != 0 {
+		//控制帧没有 Header，只需要其中的 seq
+		return req,nil
+	}
+	if cc.frame.Flags&flagStream != 0 && h.ServiceMethod == "" {
+		//已经打开的流上的消息，消息体留给流的 Recv 解码
+		return req,nil
+	}
+	//客户端上下文的截止时间随 Header 一起发送，服务端据此为请求设置截止时间
+	if h.Timeout > 0 {
+		ctx,req.cancel = context.WithTimeout(ctx,h.Timeout)
+	} else {
+		ctx,req.cancel = context.WithCancel(ctx)
+	}
+	req.ctx,req.replyMd = newRequestContext(ctx,h.Metadata)
 	// TODO: now we don't know the type of request argv
 	req.svc,req.mtype,err = server.findService(h.ServiceMethod)
 	if err!=nil {
+		// 找不到对应的方法时直接丢弃这个帧的消息体，不需要解码
+		_ = cc.ReadBody(nil)
 		return req,err
 	}
+	//客户端需要通过 Client.Stream 调用服务端流方法，通过 Client.NewStream 调用双向流方法
+	if isStream := cc.frame.Flags&flagStream != 0; isStream != req.mtype.stream {
+		_ = cc.ReadBody(nil)
+		if req.mtype.stream {
+			return req,fmt.Errorf("rpc server: %s is a streaming method",h.ServiceMethod)
+		}
+		return req,fmt.Errorf("rpc server: %s is not a streaming method",h.ServiceMethod)
+	}
+	if req.mtype.IsDuplex() {
+		_ = cc.ReadBody(nil)
+		return req,nil
+	}
+	if req.mtype.stream && cc.frame.Flags&flagEndStream == 0 {
+		_ = cc.ReadBody(nil)
+		return req,fmt.Errorf("rpc server: %s is a server-streaming method",h.ServiceMethod)
+	}
+	if !req.mtype.HasReply() && !req.oneWay {
+		_ = cc.ReadBody(nil)
+		return req,fmt.Errorf("rpc server: %s has no reply, use Client.Notify",h.ServiceMethod)
+	}
 	//通过 newArgv() 和 newReplyv() 两个方法创建出两个入参实例，
+	//流式方法的第二个参数是 *ServerStream，在开始处理请求时创建
 	req.argv = req.mtype.newArgv()
-	req.replyv = req.mtype.newReplyv()
+	if !req.mtype.stream && req.mtype.HasReply() {
+		req.replyv = req.mtype.newReplyv()
+	}
 	// day 1, just suppose it's string
 	argvi := req.argv.Interface()
 	//通过 cc.ReadBody() 将请求报文反序列化为第一个入参 argv
@@ -138,7 +408,7 @@ func (server *Server) readRequest(cc codec.Codec) (*request,error) {
 	}
 	if err =cc.ReadBody(argvi);err!=nil {
 		log.Println("rpc server:read body err:",err)
-		return req,nil
+		return req,err
 	}
 	return req,nil
 }
@@ -154,48 +424,102 @@ func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interfa
 }
 
 func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup,timeout time.Duration) {
-	// TODO, should call registered rpc methods to get the right replyv
-	// day 1, just print argv and send a hello message
 	defer wg.Done()
-	called :=make(chan struct{})
-	sent :=make(chan struct{})
-	//通过 req.svc.call 完成方法调用，将 replyv 传递给 sendResponse 完成序列化即可。
+	defer req.cancel()
+	//HandleTimeout 作为请求上下文的截止时间，超时或连接断开时上下文被取消，
+	//接受 context.Context 的方法可以据此提前结束。
+	ctx, cancel := req.ctx, context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	if req.stream != nil {
+		req.stream.ctx = ctx
+	}
+	//called 带缓冲，超时返回后方法调用的协程仍然可以写入并退出，不会泄漏。
+	called :=make(chan error, 1)
+	//经过拦截器通过 req.svc.call 完成方法调用，将 replyv 传递给 sendResponse 完成序列化即可。
 	go func() {
-		err := req.svc.call(req.mtype,req.argv,req.replyv)
-		called <- struct{}{}
+		called <- server.invoke(ctx,req)
+	}()
+	select {
+	case err := <-called:
+		// 响应复用请求的 Header，元数据替换为处理请求时设置的响应元数据
+		req.h.Metadata = req.replyMd.get()
 		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc,req.h,invalidRequest,sending)
-			sent <- struct{}{}
+			req.h.Error = server.errorMessage(err)
+			server.sendReply(cc,req,invalidRequest,sending)
 			return
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-		sent <- struct{}{}
-	}()
-	if timeout ==0 {
-		<-called
-		<-sent
-		return
+		var body interface{} = invalidRequest
+		if req.replyv.IsValid() {
+			body = req.replyv.Interface()
+		}
+		server.sendReply(cc, req, body, sending)
+	case <-ctx.Done():
+		//客户端已经取消了调用或者已经超过了客户端的截止时间，客户端不会再处理响应，不必发送
+		if atomic.LoadInt32(&req.canceled) == 1 || req.ctx.Err() != nil {
+			return
+		}
+		//超时后只发送一次错误响应，方法稍后返回的结果会被丢弃
+		if ctx.Err() == context.DeadlineExceeded {
+			req.h.Error = fmt.Sprintf("%s: expect within %s", ErrHandleTimeout, timeout)
+		} else {
+			req.h.Error = "rpc server: request canceled: " + ctx.Err().Error()
+		}
+		req.h.Metadata = req.replyMd.get()
+		server.sendReply(cc,req,invalidRequest,sending)
 	}
-	select {
-		case <-time.After(timeout):
-			req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-			server.sendResponse(cc,req.h,invalidRequest,sending)
-		case <-called:
-			<-sent
+}
 
+// sendReply 发送请求的响应，流式方法发送流的结束帧，之后流上不能再发送消息。
+func (server *Server) sendReply(cc codec.Codec, req *request, body interface{}, sending *sync.Mutex) {
+	if req.oneWay {
+		if req.h.Error != "" {
+			log.Println("rpc server: notification error:", req.h.Error)
+		}
+		return
 	}
-
+	if req.stream != nil {
+		req.stream.end(req.h)
+		return
+	}
+	server.sendResponse(cc, req.h, body, sending)
 }
+// errorMessage 返回发送给客户端的错误信息，服务方法发生 panic 时通知 OnPanic。
+func (server *Server) errorMessage(err error) string {
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		return err.Error()
+	}
+	if server.OnPanic != nil {
+		server.OnPanic(pe)
+	} else {
+		log.Printf("%v\n%s", pe, pe.Stack)
+	}
+	if server.SendPanicStack {
+		return pe.Error() + "\n" + string(pe.Stack)
+	}
+	return pe.Error()
+}
+
 //实现了 Accept 方式，net.Listener 作为参数，
 //for 循环等待 socket 连接建立，
 //并开启子协程处理，处理过程交给了 ServerConn 方法
 
+//调用 Shutdown 或 Close 之后 listener 被关闭，Accept 随之返回。
 func (server *Server) Accept(lis net.Listener)  {
+	if !server.trackListener(lis, true) {
+		_ = lis.Close()
+		return
+	}
+	defer server.trackListener(lis, false)
 	for  {
 		conn,err:=lis.Accept()
 		if err != nil {
-			log.Println("rpc server:accept error:",err)
+			if !server.isShuttingDown() {
+				log.Println("rpc server:accept error:",err)
+			}
 			return
 		}
 		go server.ServeConn(conn)
@@ -255,7 +579,7 @@ const (
 )
 //客户端向 RPC 服务器发送 CONNECT 请求
 //RPC 服务器返回 HTTP 200 状态码表示连接建立。
-//客户端使用创建好的连接发送 RPC 报文，先发送 Option，再发送 N 个请求报文，服务端处理 RPC 请求并响应。
+//客户端使用创建好的连接发送 RPC 报文，先发送握手帧，再发送 N 个请求帧，服务端处理 RPC 请求并响应。
 
 func (server *Server) ServeHTTP(w http.ResponseWriter,req *http.Request)  {
 	if req.Method!="CONNECT" {
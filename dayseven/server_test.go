@@ -0,0 +1,113 @@
+package minirpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Sleeper int
+
+func (s Sleeper) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func startSleeperServer() (*Server, string) {
+	server := NewServer()
+	var s Sleeper
+	_ = server.Register(&s)
+	l, err := net.Listen("tcp", ":0")
+	_assert(err == nil, "failed to listen: %v", err)
+	go server.Accept(l)
+	return server, l.Addr().String()
+}
+
+// Shutdown 等待正在处理的请求完成，之后的新调用得到 ErrServerShuttingDown。
+func TestServer_Shutdown(t *testing.T) {
+	t.Parallel()
+	server, addr := startSleeperServer()
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	slow := client.Go("Sleeper.Sleep", 300, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+	done := make(chan error, 1)
+	go func() { done <- server.Shutdown(context.Background()) }()
+
+	for client.IsAvailable() {
+		time.Sleep(time.Millisecond * 10)
+	}
+	var r int
+	err = client.Call(context.Background(), "Sleeper.Sleep", 1, &r)
+	_assert(errors.Is(err, ErrServerShuttingDown), "expect ErrServerShuttingDown, got %v", err)
+
+	slow = <-slow.Done
+	_assert(slow.Error == nil && reply == 300, "the in-flight call should complete: %v", slow.Error)
+	select {
+	case err = <-done:
+		_assert(err == nil, "Shutdown failed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Shutdown didn't return after in-flight calls completed")
+	}
+	_, err = Dial("tcp", addr)
+	_assert(err != nil, "the listener should be closed after Shutdown")
+}
+
+// Shutdown 的 ctx 先结束时返回 ctx.Err()，Close 强制关闭连接，等待中的调用失败。
+func TestServer_Close(t *testing.T) {
+	t.Parallel()
+	server, addr := startSleeperServer()
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	slow := client.Go("Sleeper.Sleep", 2000, &reply, nil)
+	time.Sleep(time.Millisecond * 50)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_assert(server.Shutdown(ctx) == context.DeadlineExceeded, "expect Shutdown to time out")
+	_assert(server.Close() == nil, "Close failed")
+	select {
+	case slow = <-slow.Done:
+		// 请求可能已经被处理过，不能当作服务端拒绝了它
+		_assert(errors.Is(slow.Error, ErrUnavailable) && !errors.Is(slow.Error, ErrServerShuttingDown),
+			"expect the pending call to fail with ErrUnavailable, got %v", slow.Error)
+	case <-time.After(time.Second):
+		t.Fatal("the pending call wasn't terminated by Close")
+	}
+}
+
+func (s Sleeper) Panic(msg string, reply *int) error {
+	panic(msg)
+}
+
+// 服务方法 panic 之后客户端得到错误响应，连接和服务端继续可用。
+func TestServer_panic(t *testing.T) {
+	t.Parallel()
+	server, addr := startSleeperServer()
+	defer func() { _ = server.Close() }()
+	panics := make(chan *PanicError, 1)
+	server.OnPanic = func(err *PanicError) { panics <- err }
+	server.SendPanicStack = true
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Sleeper.Panic", "boom", &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "Sleeper.Panic panic: boom"), "unexpected error: %v", err)
+	_assert(strings.Contains(err.Error(), "goroutine"), "expect the stack in the error: %v", err)
+	pe := <-panics
+	_assert(pe.ServiceMethod == "Sleeper.Panic", "unexpected OnPanic argument: %v", pe)
+
+	err = client.Call(context.Background(), "Sleeper.Sleep", 1, &reply)
+	_assert(err == nil && reply == 1, "the connection should still work: %v", err)
+}
@@ -1,9 +1,12 @@
 package minirpc
 
 import (
+	"context"
+	"fmt"
 	"go/ast"
 	"log"
 	"reflect"
+	"runtime"
 	"sync/atomic"
 )
 //反射是指在程序运行期对程序本身进行访问和修改的能力。
@@ -12,6 +15,31 @@ type methodType struct {
 	ArgType	reflect.Type //第一个参数的类型
 	ReplyType reflect.Type //第二个参数的类型
 	numCalls uint64 //方法调用次数
+	numPanics uint64 //方法发生 panic 的次数
+	numDenied uint64 //因为没有权限被拒绝的调用次数
+	hasContext bool //方法的第一个参数是否为 context.Context
+	stream bool //方法的最后一个参数是 *ServerStream，通过它收发多条消息
+}
+
+// PanicError is returned by a method call that panicked.
+// 服务方法发生 panic 时不会导致整个服务端进程崩溃，而是转换为错误响应。
+type PanicError struct {
+	ServiceMethod string // "Service.Method"
+	Value interface{} // recover() 得到的值
+	Stack []byte // 发生 panic 时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("rpc server: %s panic: %v", e.ServiceMethod, e.Value)
+}
+
+// newPanicError 记录一次 panic，并把 recover() 得到的 r 和当前的调用栈包装为 *PanicError，
+// 在 recover 所在的 defer 中调用。
+func (m *methodType) newPanicError(serviceMethod string, r interface{}) *PanicError {
+	atomic.AddUint64(&m.numPanics, 1)
+	stack := make([]byte, 64<<10)
+	stack = stack[:runtime.Stack(stack, false)]
+	return &PanicError{ServiceMethod: serviceMethod, Value: r, Stack: stack}
 }
 
 //接收者。这里是定义他们的方法有两种。如果你想修改接收器
@@ -21,6 +49,35 @@ type methodType struct {
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
+
+func (m *methodType) NumPanics() uint64 {
+	return atomic.LoadUint64(&m.numPanics)
+}
+
+func (m *methodType) NumDenied() uint64 {
+	return atomic.LoadUint64(&m.numDenied)
+}
+
+// TakesContext reports whether the method takes a context.Context as its first argument.
+func (m *methodType) TakesContext() bool {
+	return m.hasContext
+}
+// IsStream reports whether the method sends its replies through a *ServerStream.
+func (m *methodType) IsStream() bool {
+	return m.stream
+}
+
+// HasReply reports whether the method has a reply argument.
+// 没有返回值的方法只能通过 Client.Notify 调用。
+func (m *methodType) HasReply() bool {
+	return m.ReplyType != nil
+}
+
+// IsDuplex reports whether the method also receives its arguments through the stream.
+// 双向流方法没有 ArgType，客户端通过 Client.NewStream 调用。
+func (m *methodType) IsDuplex() bool {
+	return m.stream && m.ArgType == nil
+}
 //newArgv() 和 newReplyv() 两个方法创建出两个入参实例，
 //然后通过 cc.ReadBody() 将请求报文反序列化为第一个入参 argv，
 //在这里同样需要注意 argv 可能是值类型，也可能是指针类型
@@ -34,7 +91,7 @@ func (m *methodType) newArgv() reflect.Value {
 	return argv
 }
 
-func (m methodType) newReplyv() reflect.Value  {
+func (m *methodType) newReplyv() reflect.Value  {
 	//reply must be a pointer type
 	//reflect.New()函数用于获取表示指向新零值的指针的Value指定的类型。
 	replyv := reflect.New(m.ReplyType.Elem())
@@ -68,28 +125,65 @@ func newService(rcvr interface{}) *service {
 	s.registerMethods()
 	return s
 }
+var (
+	typeOfError   = reflect.TypeOf((*error)(nil)).Elem()
+	typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+	typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+)
+
 //registerMethods 过滤出了符合条件的方法：
+//func (t *T) MethodName(argType T1, replyType *T2) error
+//func (t *T) MethodName(ctx context.Context, argType T1, replyType *T2) error
+//func (t *T) MethodName(argType T1, stream *ServerStream) error
+//func (t *T) MethodName(stream *ServerStream) error
+//func (t *T) MethodName(ctx context.Context, argType T1) error
 func (s *service) registerMethods() {
 	s.method = make(map[string]*methodType)
 	for i:=0;i<s.typ.NumMethod();i++ {
 	method :=s.typ.Method(i)
 	mType := method.Type
 		//两个导出或内置类型的入参（反射时为 3 个，第 0 个是自身，类似于 python 的 self，java 中的 this）
+		//也可以在这两个参数之前增加一个 context.Context 参数
+		//双向流方法只有一个 *ServerStream 参数
+		//单向调用的方法必须显式接受 context.Context，之后只有一个非接口类型的参数，没有返回值，
+		//这样 func(ctx context.Context) error、func(v interface{}) error 之类的方法不会被误注册
 		//返回值有且只有 1 个，类型为 error
-		if mType.NumIn()!=3||mType.NumOut()!=1 {
+		numIn := mType.NumIn()
+		hasContext := numIn>=3 && mType.In(1)==typeOfContext
+		numArgs := numIn-1
+		if hasContext {
+			numArgs--
+		}
+		if numArgs<1 || numArgs>2 || mType.NumOut()!=1 {
+			continue
+		}
+		if mType.Out(0)!=typeOfError {
 			continue
 		}
-		if mType.Out(0)!=reflect.TypeOf((*error)(nil)).Elem() {
+		var argType, replyType reflect.Type
+		if last := mType.In(numIn-1); numArgs==1 && last!=typeOfServerStream {
+			if !hasContext || last.Kind()==reflect.Interface {
+				continue
+			}
+			argType = last
+		} else {
+			replyType = last
+			if numArgs==2 {
+				argType = mType.In(numIn-2)
+			}
+		}
+		if argType!=nil && !isExportedOrBuiltinType(argType) {
 			continue
 		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		if replyType!=nil && !isExportedOrBuiltinType(replyType) {
 			continue
 		}
 		s.method[method.Name]=&methodType{
 			method:method,
 			ArgType: argType,
 			ReplyType: replyType,
+			hasContext: hasContext,
+			stream: replyType==typeOfServerStream,
 		}
 	}
 }
@@ -98,12 +192,32 @@ func isExportedOrBuiltinType(t reflect.Type) bool {
 	//sExported 报告名称是否为导出的 Go 符号（即，它是否以大写字母开头）。 
 	return ast.IsExported(t.Name()) ||t.PkgPath()==""
 }
-//能够通过反射值调用方法。
-func (s *service) call(m *methodType,argv,replgv reflect.Value) error {
+//能够通过反射值调用方法，方法接受 context.Context 时传入 ctx。
+//方法发生 panic 时返回 *PanicError。
+func (s *service) call(m *methodType,ctx context.Context,argv,replgv reflect.Value) (err error) {
 	//addr表示地址，而delta表示少量大于零的位
 	atomic.AddUint64(&m.numCalls,1)
+	defer func() {
+		if r := recover(); r != nil {
+			err = m.newPanicError(s.name+"."+m.method.Name, r)
+		}
+	}()
 	f:=m.method.Func
-	returnValues :=f.Call([]reflect.Value{s.rcvr,argv,replgv})
+	in := []reflect.Value{s.rcvr}
+	if m.hasContext {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	//双向流方法没有 argv，单向调用的方法没有 replyv
+	if argv.IsValid() {
+		in = append(in, argv)
+	}
+	if replgv.IsValid() {
+		in = append(in, replgv)
+	}
+	returnValues :=f.Call(in)
 	if errInter:=returnValues[0].Interface();errInter!=nil {
 		return errInter.(error)
 	}
@@ -1,6 +1,7 @@
 package minirpc
 
 import (
+	"context"
 	"fmt"
 	"reflect"
 	"testing"
@@ -42,6 +43,61 @@ func TestMethodType_Call(t *testing.T) {
 	argv :=mType.newArgv()
 	replyv :=mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1:1,Num2: 3}))
-	err:=s.call(mType,argv,replyv)
+	err:=s.call(mType,context.Background(),argv,replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
-}
\ No newline at end of file
+}
+type Ctx int
+
+func (c Ctx) Echo(ctx context.Context, args string, reply *string) error {
+	*reply = args + MetadataFromContext(ctx)["suffix"]
+	return nil
+}
+
+// 第一个参数为 context.Context 的方法同样会被注册，调用时传入 ctx。
+func TestMethodType_CallContext(t *testing.T) {
+	var c Ctx
+	s := newService(&c)
+	mType := s.method["Echo"]
+	_assert(mType != nil && mType.TakesContext(), "Echo should take a context")
+	argv := mType.newArgv()
+	replyv := mType.newReplyv()
+	argv.Set(reflect.ValueOf("hello"))
+	ctx, _ := newRequestContext(context.Background(), Metadata{"suffix": "!"})
+	err := s.call(mType, ctx, argv, replyv)
+	_assert(err == nil && *replyv.Interface().(*string) == "hello!", "failed to call Ctx.Echo")
+}
+
+func (c Ctx) Panic(args string, reply *string) error {
+	panic(args)
+}
+
+// 服务方法发生 panic 时返回 *PanicError，并记录 panic 次数。
+func TestMethodType_CallPanic(t *testing.T) {
+	var c Ctx
+	s := newService(&c)
+	mType := s.method["Panic"]
+	argv := mType.newArgv()
+	argv.Set(reflect.ValueOf("boom"))
+	err := s.call(mType, context.Background(), argv, mType.newReplyv())
+	pe, ok := err.(*PanicError)
+	_assert(ok && pe.ServiceMethod == "Ctx.Panic" && pe.Value == "boom", "expect a PanicError, got %v", err)
+	_assert(len(pe.Stack) > 0 && mType.NumPanics() == 1, "the panic should be recorded")
+}
+
+type Events int
+
+func (e Events) Publish(ctx context.Context, topic string) error { return nil }
+func (e Events) Plain(topic string) error                        { return nil }
+func (e Events) Wait(ctx context.Context) error                  { return nil }
+func (e Events) Any(ctx context.Context, v interface{}) error    { return nil }
+
+// 只有显式接受 context.Context、参数不是接口类型的方法才会被注册为单向调用的方法。
+func TestNewServer_oneWay(t *testing.T) {
+	var e Events
+	s := newService(&e)
+	mType := s.method["Publish"]
+	_assert(mType != nil && !mType.HasReply() && mType.ArgType.Kind() == reflect.String, "Publish should be a one-way method")
+	for _, name := range []string{"Plain", "Wait", "Any"} {
+		_assert(s.method[name] == nil, "%s shouldn't be registered", name)
+	}
+}
@@ -0,0 +1,147 @@
+package minirpc
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// serverConn 是服务端上的一个连接，Shutdown 和 Close 通过它通知或关闭客户端。
+type serverConn struct {
+	cc      *frameCodec
+	sending *sync.Mutex
+	ready   bool // 握手已经完成，由 server.mu 保护
+}
+
+// goAway 通知客户端不要再在这个连接上发起新的调用，已经发出的调用仍会得到响应。
+func (sc *serverConn) goAway() error {
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	return sc.cc.writeFrame(flagGoAway, 0, nil)
+}
+
+// trackListener 记录 Accept 正在使用的 listener，服务端已经关闭时返回 false。
+func (server *Server) trackListener(lis net.Listener, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.listeners, lis)
+		return true
+	}
+	if server.shuttingDown {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+// trackConn 记录服务端上的连接，服务端已经关闭时返回 false。
+func (server *Server) trackConn(sc *serverConn, add bool) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if !add {
+		delete(server.conns, sc)
+		return true
+	}
+	if server.shuttingDown {
+		return false
+	}
+	if server.conns == nil {
+		server.conns = make(map[*serverConn]struct{})
+	}
+	server.conns[sc] = struct{}{}
+	return true
+}
+
+// connReady 标记握手已经完成，返回 false 表示服务端已经开始关闭。
+func (server *Server) connReady(sc *serverConn) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	sc.ready = true
+	return !server.shuttingDown
+}
+
+// beginRequest 在开始处理一个请求之前调用，服务端开始关闭之后不再接受新的请求。
+// 返回 true 时，请求处理完成后需要调用 server.handlers.Done()。
+func (server *Server) beginRequest() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.shuttingDown {
+		return false
+	}
+	server.handlers.Add(1)
+	return true
+}
+
+// closeListenersLocked 标记服务端正在关闭，关闭所有 listener，并返回当前的所有连接。
+func (server *Server) closeListenersLocked() []*serverConn {
+	server.shuttingDown = true
+	for lis := range server.listeners {
+		_ = lis.Close()
+		delete(server.listeners, lis)
+	}
+	conns := make([]*serverConn, 0, len(server.conns))
+	for sc := range server.conns {
+		conns = append(conns, sc)
+	}
+	return conns
+}
+
+// Shutdown gracefully shuts down the server.
+// Shutdown 首先停止接受新的连接，然后通知所有客户端不要再发起新的调用，
+// 等待正在处理的请求全部完成并发出响应之后，关闭所有连接。
+// ctx 在此之前结束时返回 ctx.Err()，此时可以调用 Close 强制关闭连接。
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	conns := server.closeListenersLocked()
+	server.mu.Unlock()
+	for _, sc := range conns {
+		server.mu.Lock()
+		ready := sc.ready
+		server.mu.Unlock()
+		// 还在握手的连接没有正在处理的请求，直接关闭即可
+		if !ready || sc.goAway() != nil {
+			_ = sc.cc.Close()
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		server.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	server.closeConns()
+	return nil
+}
+
+// Close immediately closes all listeners and connections.
+// 正在等待响应的客户端调用会失败。
+func (server *Server) Close() error {
+	server.mu.Lock()
+	server.closeListenersLocked()
+	server.mu.Unlock()
+	server.closeConns()
+	return nil
+}
+
+func (server *Server) closeConns() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	for sc := range server.conns {
+		_ = sc.cc.Close()
+		delete(server.conns, sc)
+	}
+}
+
+func (server *Server) isShuttingDown() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.shuttingDown
+}
@@ -0,0 +1,459 @@
+package minirpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"minirpc/codec"
+	"sync"
+)
+
+// 流式调用以请求的 seq 作为流的 ID，一个连接上可以同时存在多个流：
+// 客户端发送一个带有 flagStream 的请求帧打开流，之后双方在同一个 seq 上发送 flagStream 消息帧，
+// 客户端发送的消息帧中 ServiceMethod 为空，以区别于打开流的请求帧。
+// 一方不再发送时发送 flagStream|flagEndStream 的结束帧（半关闭），另一方的 Recv 在取完消息后返回 io.EOF，
+// 服务端的结束帧表示整个流结束，Header 中带有方法返回的错误和响应元数据。
+// 服务端流方法的请求帧同时带有 flagEndStream，表示客户端不会再发送消息。
+//
+// 流控以消息数为单位，每个流、每个方向单独计算：发送方最多可以发送 StreamWindow 条接收方还没有处理的消息，
+// 接收方每处理完半个窗口的消息，就通过 flagWindow 帧把窗口还给发送方。
+// 处理得慢的接收方会让发送方的 Send 阻塞，而不是让消息在内存中无限堆积。
+
+// DefaultStreamWindow 是 Option.StreamWindow 为 0 时使用的窗口大小。
+const DefaultStreamWindow = 64
+
+// ErrStreamClosed is returned when using a stream that has already ended.
+var ErrStreamClosed = errors.New("rpc: stream closed")
+
+var errWindowExceeded = errors.New("rpc: stream flow control window exceeded")
+
+func streamWindow(n int) int {
+	if n <= 0 {
+		return DefaultStreamWindow
+	}
+	return n
+}
+
+// windowIncrement 解析流控帧的载荷。
+func windowIncrement(payload []byte) int {
+	if len(payload) != 4 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint32(payload))
+}
+
+// writeWindow 发送流控帧，sending 锁由调用方持有。
+func writeWindow(cc *frameCodec, seq uint64, n int) error {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(n))
+	return cc.writeFrame(flagWindow, seq, b[:])
+}
+
+// streamInbox 保存流上收到、还没有被 Recv 取走的消息。
+type streamInbox struct {
+	window int
+
+	mu       sync.Mutex
+	queue    []codec.Codec
+	consumed int   // 已经取走、还没有还给对方的窗口
+	err      error // 队列取空之后 Recv 返回的错误
+	notify   chan struct{}
+}
+
+func newStreamInbox(window int) *streamInbox {
+	return &streamInbox{window: window, notify: make(chan struct{}, 1)}
+}
+
+// push 放入一条消息，对方不遵守流控发送过多的消息时，流以错误结束，
+// exceeded 为 true，调用方需要通知对方停止发送。
+func (in *streamInbox) push(body codec.Codec) (exceeded bool) {
+	in.mu.Lock()
+	if in.err == nil {
+		if len(in.queue)+in.consumed >= in.window {
+			in.queue, in.err = nil, errWindowExceeded
+			exceeded = true
+		} else {
+			in.queue = append(in.queue, body)
+		}
+	}
+	in.mu.Unlock()
+	in.wake()
+	return exceeded
+}
+
+// pop 取出一条消息，grant 是需要还给对方的窗口。
+// 队列为空时 body 为 nil，返回流结束的原因，err 也为 nil 表示需要等待。
+func (in *streamInbox) pop() (body codec.Codec, grant int, err error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.queue) == 0 {
+		return nil, 0, in.err
+	}
+	body = in.queue[0]
+	in.queue[0] = nil
+	in.queue = in.queue[1:]
+	// 对方已经不再发送时不需要归还窗口
+	if in.consumed++; in.err == nil && in.consumed >= (in.window+1)/2 {
+		grant, in.consumed = in.consumed, 0
+	}
+	return body, grant, nil
+}
+
+// finish 标记对方不会再发送消息，队列中已有的消息仍然可以取出。
+func (in *streamInbox) finish(err error) {
+	in.mu.Lock()
+	if in.err == nil {
+		in.err = err
+	}
+	in.mu.Unlock()
+	in.wake()
+}
+
+// discard 丢弃还没有取出的消息。
+func (in *streamInbox) discard(err error) {
+	in.mu.Lock()
+	in.queue = nil
+	if in.err == nil {
+		in.err = err
+	}
+	in.mu.Unlock()
+	in.wake()
+}
+
+func (in *streamInbox) wake() {
+	select {
+	case in.notify <- struct{}{}:
+	default:
+	}
+}
+
+// streamOutbox 记录对方给出的窗口，窗口用完时 Send 阻塞。
+type streamOutbox struct {
+	mu     sync.Mutex
+	credit int   // 还可以发送的消息数
+	err    error // 不能再发送时 Send 返回的错误
+	more   chan struct{}
+}
+
+func newStreamOutbox(window int) *streamOutbox {
+	return &streamOutbox{credit: window, more: make(chan struct{}, 1)}
+}
+
+// acquire 等待并占用一个窗口，成功返回时持有 out.mu，调用方写出消息之后释放，
+// 这样结束帧不会在正在发送的消息之前写出。
+func (out *streamOutbox) acquire(ctx context.Context) error {
+	for {
+		out.mu.Lock()
+		if out.err != nil {
+			err := out.err
+			out.mu.Unlock()
+			return err
+		}
+		if out.credit > 0 {
+			out.credit--
+			return nil
+		}
+		out.mu.Unlock()
+		select {
+		case <-out.more:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (out *streamOutbox) grant(n int) {
+	out.mu.Lock()
+	out.credit += n
+	out.mu.Unlock()
+	out.wake()
+}
+
+// close 之后 Send 返回 err，返回 false 表示已经关闭过。
+func (out *streamOutbox) close(err error) bool {
+	out.mu.Lock()
+	first := out.err == nil
+	if first {
+		out.err = err
+	}
+	out.mu.Unlock()
+	out.wake()
+	return first
+}
+
+func (out *streamOutbox) wake() {
+	select {
+	case out.more <- struct{}{}:
+	default:
+	}
+}
+
+// ServerStream sends and receives the messages of a streaming method.
+// 流式方法的签名为：
+//
+//	func (t *T) MethodName(argType T1, stream *minirpc.ServerStream) error
+//	func (t *T) MethodName(stream *minirpc.ServerStream) error
+//
+// 第一种是服务端流，客户端通过 Client.Stream 发送参数，之后只接收消息；
+// 第二种是双向流，客户端通过 Client.NewStream 打开流，双方都可以通过 Send 和 Recv 收发消息，
+// 客户端流可以看作在 Recv 返回 io.EOF 之后只 Send 一次的双向流。
+// 两种方法都可以在最前面增加一个 context.Context 参数。
+// 方法返回之后流即结束，返回的错误会在客户端的 Recv 中得到。
+type ServerStream struct {
+	ctx           context.Context
+	serviceMethod string
+	seq           uint64
+	cc            *frameCodec
+	sending       *sync.Mutex
+	in            *streamInbox
+	out           *streamOutbox
+}
+
+func newServerStream(req *request, cc *frameCodec, sending *sync.Mutex, window int, closeRecv bool) *ServerStream {
+	window = streamWindow(window)
+	s := &ServerStream{
+		ctx:           req.ctx,
+		serviceMethod: req.h.ServiceMethod,
+		seq:           req.seq,
+		cc:            cc,
+		sending:       sending,
+		in:            newStreamInbox(window),
+		out:           newStreamOutbox(window),
+	}
+	if closeRecv {
+		s.in.finish(io.EOF)
+	}
+	return s
+}
+
+// Context returns the context of the request.
+func (s *ServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// Send sends v to the client.
+// 客户端的窗口用完时 Send 阻塞，直到客户端处理了消息，或者请求被取消。
+func (s *ServerStream) Send(v interface{}) error {
+	if err := s.out.acquire(s.ctx); err != nil {
+		return err
+	}
+	defer s.out.mu.Unlock()
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	err := s.cc.writeMessage(flagStream, &codec.Header{ServiceMethod: s.serviceMethod, Seq: s.seq}, v)
+	if err != nil {
+		s.out.credit++
+	}
+	return err
+}
+
+// Recv receives the next message from the client into v.
+// 客户端结束发送之后返回 io.EOF，服务端流方法的 Recv 总是返回 io.EOF。
+func (s *ServerStream) Recv(v interface{}) error {
+	for {
+		body, grant, err := s.in.pop()
+		if body != nil {
+			if grant > 0 {
+				s.sending.Lock()
+				_ = writeWindow(s.cc, s.seq, grant)
+				s.sending.Unlock()
+			}
+			return body.ReadBody(v)
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-s.in.notify:
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+}
+
+// end 发送结束帧，h 中带有方法返回的错误，之后 Send 返回 ErrStreamClosed。
+func (s *ServerStream) end(h *codec.Header) {
+	s.out.mu.Lock()
+	defer s.out.wake()
+	defer s.out.mu.Unlock()
+	s.out.err = ErrStreamClosed
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	_ = s.cc.writeMessage(flagStream|flagEndStream, h, invalidRequest)
+}
+
+// ClientStream is the client side of a streaming call.
+type ClientStream struct {
+	client    *Client
+	call      *Call
+	ctx       context.Context
+	closeSend bool // 打开流的同时结束发送
+	in        *streamInbox
+	out       *streamOutbox
+	abortOnce sync.Once
+	aborted   chan struct{} // abort 之后关闭
+}
+
+// Stream invokes a server-streaming method.
+// 通过 Recv 依次读取服务端发送的消息，流正常结束时 Recv 返回 io.EOF。
+// ctx 结束或者调用 Close 时，服务端的请求会被取消。
+//
+//	stream, err := client.Stream(ctx, "Export.Records", args)
+//	for err == nil {
+//		var r Record
+//		if err = stream.Recv(&r); err == nil {
+//			...
+//		}
+//	}
+//	if err != io.EOF { ... }
+func (client *Client) Stream(ctx context.Context, serviceMethod string, args interface{}) (*ClientStream, error) {
+	return client.openStream(ctx, serviceMethod, args, true)
+}
+
+// NewStream opens a bidirectional stream to a method that takes only a *ServerStream.
+// 通过 Send 发送消息，CloseSend 结束发送，Recv 读取服务端发送的消息。
+// 同一个连接上的多个流互不影响，各自有独立的流控窗口。
+func (client *Client) NewStream(ctx context.Context, serviceMethod string) (*ClientStream, error) {
+	return client.openStream(ctx, serviceMethod, invalidRequest, false)
+}
+
+func (client *Client) openStream(ctx context.Context, serviceMethod string, args interface{}, closeSend bool) (*ClientStream, error) {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Metadata:      outgoingMetadata(ctx),
+		Done:          make(chan *Call, 1),
+	}
+	call.deadline, _ = ctx.Deadline()
+	window := streamWindow(client.opt.StreamWindow)
+	s := &ClientStream{
+		client:    client,
+		call:      call,
+		ctx:       ctx,
+		closeSend: closeSend,
+		in:        newStreamInbox(window),
+		out:       newStreamOutbox(window),
+		aborted:   make(chan struct{}),
+	}
+	if closeSend {
+		s.out.close(ErrStreamClosed)
+	}
+	call.stream = s
+	client.send(call)
+	select {
+	case <-call.Done:
+		// 请求没有发送出去，或者服务端已经返回了结果
+		if call.Error != nil {
+			return nil, call.Error
+		}
+		s.in.finish(io.EOF)
+	default:
+		go s.watch()
+	}
+	return s, nil
+}
+
+// watch 等待流结束。ctx 先结束时取消服务端的请求，
+// 这样调用方不再调用 Recv 或 Close 时，客户端的 pending 和服务端的方法也不会泄漏。
+func (s *ClientStream) watch() {
+	select {
+	case call := <-s.call.Done:
+		// 结束帧之前的消息都已经放入队列
+		if call.Error != nil {
+			s.in.finish(call.Error)
+		} else {
+			s.in.finish(io.EOF)
+		}
+	case <-s.ctx.Done():
+		s.abort(errors.New("rpc client: call failed:" + s.ctx.Err().Error()))
+	case <-s.aborted:
+	}
+}
+
+// Send sends v to the server.
+// 服务端的窗口用完时 Send 阻塞，服务端已经结束流时返回 io.EOF，错误由 Recv 返回。
+func (s *ClientStream) Send(v interface{}) error {
+	if err := s.out.acquire(s.ctx); err != nil {
+		return err
+	}
+	defer s.out.mu.Unlock()
+	err := s.client.sendStream(s.call.Seq, flagStream, v)
+	if err != nil {
+		s.out.credit++
+	}
+	return err
+}
+
+// CloseSend tells the server that no more messages will be sent.
+// 服务端的 Recv 在取完消息之后返回 io.EOF，客户端仍然可以继续 Recv。
+func (s *ClientStream) CloseSend() error {
+	s.out.mu.Lock()
+	defer s.out.mu.Unlock()
+	if s.out.err != nil {
+		return nil
+	}
+	s.out.err = ErrStreamClosed
+	return s.client.sendStream(s.call.Seq, flagStream|flagEndStream, invalidRequest)
+}
+
+// Recv receives the next message into reply.
+// 流正常结束时返回 io.EOF，服务端方法返回错误时返回该错误。
+func (s *ClientStream) Recv(reply interface{}) error {
+	for {
+		body, grant, err := s.in.pop()
+		if body != nil {
+			if grant > 0 {
+				s.client.sendWindow(s.call.Seq, grant)
+			}
+			return body.ReadBody(reply)
+		}
+		if err != nil {
+			return err
+		}
+		// 流结束、ctx 结束时 watch 会唤醒这里
+		<-s.in.notify
+	}
+}
+
+// ReplyMetadata returns the metadata the server sent with the end of the stream.
+// 只有 Recv 返回 io.EOF 之后才有效。
+func (s *ClientStream) ReplyMetadata() Metadata {
+	s.in.mu.Lock()
+	defer s.in.mu.Unlock()
+	if s.in.err != io.EOF {
+		return nil
+	}
+	return s.call.ReplyMetadata
+}
+
+// Close cancels the stream if it hasn't ended.
+func (s *ClientStream) Close() error {
+	s.abort(ErrStreamClosed)
+	return nil
+}
+
+// abort 在流结束之前取消服务端的请求，丢弃还没有读取的消息。
+func (s *ClientStream) abort(err error) {
+	s.abortOnce.Do(func() { close(s.aborted) })
+	if s.client.removeCall(s.call.Seq) != nil {
+		s.client.cancelCall(s.call.Seq)
+	}
+	s.out.close(err)
+	s.in.discard(err)
+}
+
+// sendStream 在已经打开的流上发送一条消息。
+func (client *Client) sendStream(seq uint64, flags frameFlag, body interface{}) error {
+	// 服务端正在关闭时已经打开的流仍然可以继续，连接断开时写入失败
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	return client.cc.writeMessage(flags, &codec.Header{Seq: seq}, body)
+}
+
+// sendWindow 把 n 条消息的窗口还给服务端。
+func (client *Client) sendWindow(seq uint64, n int) {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	_ = writeWindow(client.cc, seq, n)
+}
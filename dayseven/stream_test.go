@@ -0,0 +1,339 @@
+package minirpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type Counter struct {
+	sent     int32
+	canceled chan struct{}
+}
+
+// Count 依次发送 0 到 n-1，n 为负数时发送 -n 条消息之后返回错误。
+func (c *Counter) Count(n int, stream *ServerStream) error {
+	fail := n < 0
+	if fail {
+		n = -n
+	}
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			if errors.Is(err, context.Canceled) {
+				close(c.canceled)
+			}
+			return err
+		}
+		atomic.AddInt32(&c.sent, 1)
+	}
+	if fail {
+		return errors.New("count failed")
+	}
+	return SetReplyMetadata(stream.Context(), "total", "done")
+}
+
+func (c *Counter) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func startCounterServer() (*Counter, string) {
+	c := &Counter{canceled: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(c)
+	l, err := net.Listen("tcp", ":0")
+	_assert(err == nil, "failed to listen: %v", err)
+	go server.Accept(l)
+	return c, l.Addr().String()
+}
+
+func TestClient_Stream(t *testing.T) {
+	t.Parallel()
+	_, addr := startCounterServer()
+	client, err := Dial("tcp", addr, &Option{StreamWindow: 4})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.Stream(context.Background(), "Counter.Count", 100)
+	_assert(err == nil, "failed to start the stream: %v", err)
+	var got []int
+	for {
+		var i int
+		if err = stream.Recv(&i); err != nil {
+			break
+		}
+		got = append(got, i)
+	}
+	_assert(err == io.EOF && len(got) == 100 && got[99] == 99, "expect 100 messages and io.EOF, got %d (%v)", len(got), err)
+	_assert(stream.ReplyMetadata()["total"] == "done", "expect the reply metadata at the end of the stream")
+
+	stream, err = client.Stream(context.Background(), "Counter.Count", -3)
+	_assert(err == nil, "failed to start the stream: %v", err)
+	n := 0
+	for err = stream.Recv(&n); err == nil; err = stream.Recv(&n) {
+	}
+	_assert(err != nil && err.Error() == "count failed" && n == 2, "expect the method's error after 3 messages, got %v", err)
+}
+
+// 客户端不读取时，服务端最多发送一个窗口的消息就会阻塞。
+func TestClient_StreamFlowControl(t *testing.T) {
+	t.Parallel()
+	c, addr := startCounterServer()
+	client, err := Dial("tcp", addr, &Option{StreamWindow: 4})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.Stream(context.Background(), "Counter.Count", 20)
+	_assert(err == nil, "failed to start the stream: %v", err)
+	time.Sleep(100 * time.Millisecond)
+	_assert(atomic.LoadInt32(&c.sent) == 4, "expect the server to block after 4 messages, sent %d", atomic.LoadInt32(&c.sent))
+	var i int
+	for k := 0; k < 20; k++ {
+		_assert(stream.Recv(&i) == nil && i == k, "expect %d, got %d", k, i)
+	}
+	_assert(stream.Recv(&i) == io.EOF, "expect io.EOF")
+}
+
+// Close 取消服务端的请求，阻塞在 Send 中的方法得到 context.Canceled。
+func TestClientStream_Close(t *testing.T) {
+	t.Parallel()
+	c, addr := startCounterServer()
+	client, err := Dial("tcp", addr, &Option{StreamWindow: 2})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.Stream(context.Background(), "Counter.Count", 10)
+	_assert(err == nil, "failed to start the stream: %v", err)
+	var i int
+	_assert(stream.Recv(&i) == nil && i == 0, "expect the first message")
+	_ = stream.Close()
+	select {
+	case <-c.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the server method should be canceled")
+	}
+	_assert(stream.Recv(&i) == ErrStreamClosed, "expect ErrStreamClosed after Close")
+}
+
+// ctx 结束之后，即使不再调用 Recv 或 Close，服务端的请求也会被取消，客户端不再保留这个流。
+func TestClientStream_contextCanceled(t *testing.T) {
+	t.Parallel()
+	c, addr := startCounterServer()
+	client, err := Dial("tcp", addr, &Option{StreamWindow: 2})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.Stream(ctx, "Counter.Count", 10)
+	_assert(err == nil, "failed to start the stream: %v", err)
+	var i int
+	_assert(stream.Recv(&i) == nil && i == 0, "expect the first message")
+	cancel()
+	select {
+	case <-c.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the server method should be canceled")
+	}
+	_assert(client.NumPending() == 0, "the stream should be removed, %d pending", client.NumPending())
+}
+
+// 服务端发送的消息超过客户端的窗口时，客户端取消请求，服务端不再继续发送。
+func TestClientStream_windowExceeded(t *testing.T) {
+	t.Parallel()
+	c, addr := startCounterServer()
+	client, err := Dial("tcp", addr, &Option{StreamWindow: 8})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 服务端按照握手时的 8 条消息发送，客户端只接受 2 条
+	client.opt.StreamWindow = 2
+	stream, err := client.Stream(context.Background(), "Counter.Count", 100)
+	_assert(err == nil, "failed to start the stream: %v", err)
+	select {
+	case <-c.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the server method should be canceled")
+	}
+	var i int
+	err = stream.Recv(&i)
+	_assert(errors.Is(err, errWindowExceeded), "expect errWindowExceeded, got %v", err)
+	_assert(client.NumPending() == 0, "the stream should be removed, %d pending", client.NumPending())
+}
+
+// 流式方法只能通过 Stream 调用，普通方法只能通过 Call 调用。
+func TestClient_StreamMismatch(t *testing.T) {
+	t.Parallel()
+	_, addr := startCounterServer()
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call(context.Background(), "Counter.Count", 1, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "is a streaming method"), "unexpected error: %v", err)
+	stream, err := client.Stream(context.Background(), "Counter.Sum", Args{1, 2})
+	if err == nil {
+		err = stream.Recv(&reply)
+	}
+	_assert(err != nil && strings.Contains(err.Error(), "is not a streaming method"), "unexpected error: %v", err)
+}
+
+type Chat struct {
+	canceled chan struct{}
+}
+
+// Echo 把收到的每条消息加上 "!" 发送回去，直到客户端结束发送。
+func (c *Chat) Echo(stream *ServerStream) error {
+	for {
+		var msg string
+		if err := stream.Recv(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := stream.Send(msg + "!"); err != nil {
+			return err
+		}
+	}
+}
+
+// Sum 是客户端流方法，读取所有的数之后返回它们的和。
+func (c *Chat) Sum(ctx context.Context, stream *ServerStream) error {
+	total := 0
+	for {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			return stream.Send(total)
+		}
+		if err != nil {
+			return err
+		}
+		total += n
+	}
+}
+
+// Hold 不读取任何消息，直到客户端取消请求。
+func (c *Chat) Hold(stream *ServerStream) error {
+	<-stream.Context().Done()
+	close(c.canceled)
+	return stream.Context().Err()
+}
+
+func startChatServer() (*Chat, string) {
+	c := &Chat{canceled: make(chan struct{})}
+	server := NewServer()
+	_ = server.Register(c)
+	_ = server.Register(&Counter{canceled: make(chan struct{})})
+	l, err := net.Listen("tcp", ":0")
+	_assert(err == nil, "failed to listen: %v", err)
+	go server.Accept(l)
+	return c, l.Addr().String()
+}
+
+// 同一个连接上的多个双向流互不影响。
+func TestClient_NewStream(t *testing.T) {
+	t.Parallel()
+	_, addr := startChatServer()
+	client, err := Dial("tcp", addr, &Option{StreamWindow: 2})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func(i int) {
+			errs <- func() error {
+				stream, err := client.NewStream(context.Background(), "Chat.Echo")
+				if err != nil {
+					return err
+				}
+				for k := 0; k < 50; k++ {
+					want := strings.Repeat("x", i) + string(rune('a'+k%26))
+					if err = stream.Send(want); err != nil {
+						return err
+					}
+					var got string
+					if err = stream.Recv(&got); err != nil {
+						return err
+					}
+					if got != want+"!" {
+						return errors.New("unexpected reply " + got)
+					}
+				}
+				_ = stream.CloseSend()
+				var got string
+				if err = stream.Recv(&got); err != io.EOF {
+					return errors.New("expect io.EOF")
+				}
+				return nil
+			}()
+		}(i)
+	}
+	for i := 0; i < 5; i++ {
+		err := <-errs
+		_assert(err == nil, "stream failed: %v", err)
+	}
+}
+
+func TestClient_clientStream(t *testing.T) {
+	t.Parallel()
+	_, addr := startChatServer()
+	client, err := Dial("tcp", addr, &Option{CodecType: "application/json", StreamWindow: 3})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewStream(context.Background(), "Chat.Sum")
+	_assert(err == nil, "failed to open the stream: %v", err)
+	for i := 1; i <= 100; i++ {
+		_assert(stream.Send(i) == nil, "failed to send %d", i)
+	}
+	_assert(stream.CloseSend() == nil, "failed to close send")
+	_assert(stream.Send(1) == ErrStreamClosed, "expect ErrStreamClosed after CloseSend")
+	var total int
+	err = stream.Recv(&total)
+	_assert(err == nil && total == 5050, "expect 5050, got %d (%v)", total, err)
+	_assert(stream.Recv(&total) == io.EOF, "expect io.EOF")
+
+	// 服务端流方法只能通过 Stream 调用，以 Stream 调用双向流方法时服务端的 Recv 立即返回 io.EOF
+	stream, err = client.NewStream(context.Background(), "Counter.Count")
+	if err == nil {
+		err = stream.Recv(&total)
+	}
+	_assert(err != nil && strings.Contains(err.Error(), "is a server-streaming method"), "unexpected error: %v", err)
+	stream, err = client.Stream(context.Background(), "Chat.Sum", 0)
+	_assert(err == nil && stream.Recv(&total) == nil && total == 0, "expect 0, got %d (%v)", total, err)
+}
+
+// 服务端不读取时，客户端最多发送一个窗口的消息就会阻塞；Close 取消服务端的请求。
+func TestClientStream_SendFlowControl(t *testing.T) {
+	t.Parallel()
+	c, addr := startChatServer()
+	client, err := Dial("tcp", addr, &Option{StreamWindow: 2})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewStream(context.Background(), "Chat.Hold")
+	_assert(err == nil, "failed to open the stream: %v", err)
+	var sent int32
+	go func() {
+		for i := 1; i <= 10; i++ {
+			if stream.Send(i) != nil {
+				return
+			}
+			atomic.AddInt32(&sent, 1)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_assert(atomic.LoadInt32(&sent) == 2, "expect the client to block after 2 messages, sent %d", atomic.LoadInt32(&sent))
+	_ = stream.Close()
+	select {
+	case <-c.canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the server method should be canceled")
+	}
+}
@@ -0,0 +1,39 @@
+package minirpc
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// DialTLS connects to an RPC server at the specified network address over TLS.
+// config 为 nil 时使用 Option.TLSConfig，两者都为 nil 时使用默认配置，即使用系统根证书校验服务端。
+// 需要双向认证时，在 config.Certificates 中提供客户端证书。
+func DialTLS(network, address string, config *tls.Config, opts ...*Option) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = opt.TLSConfig
+	}
+	// TLS 握手和建立连接一起受 ConnectTimeout 限制
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: opt.ConnectTimeout}, network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return newClientTimeout(NewClient, conn, opt)
+}
+
+// AcceptTLS accepts TLS connections on the listener and serves requests
+// for each incoming connection.
+// 它等价于 server.Accept(tls.NewListener(lis, config))，也可以直接把 tls.Listen 得到的 listener 传给 Accept。
+// 需要双向认证时，将 config.ClientAuth 设置为 tls.RequireAndVerifyClientCert 并提供 ClientCAs，
+// 服务方法和拦截器通过 PeerFromContext 得到客户端的证书。
+func (server *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	server.Accept(tls.NewListener(lis, config))
+}
+
+// AcceptTLS accepts TLS connections on the listener for the DefaultServer.
+func AcceptTLS(lis net.Listener, config *tls.Config) {
+	DefaultServer.AcceptTLS(lis, config)
+}
@@ -0,0 +1,131 @@
+package minirpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCA 是测试时生成的自签名 CA，用它签发服务端和客户端的证书。
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA() *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_assert(err == nil, "failed to generate key: %v", err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "minirpc test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	_assert(err == nil, "failed to create CA certificate: %v", err)
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue 签发一个证书，服务端证书对 127.0.0.1 有效。
+func (ca *testCA) issue(cn string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_assert(err == nil, "failed to generate key: %v", err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	_assert(err == nil, "failed to create certificate: %v", err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+type Whoami int
+
+// Name 返回客户端证书中的 CommonName。
+func (w Whoami) Name(ctx context.Context, args int, reply *string) error {
+	p, ok := PeerFromContext(ctx)
+	if !ok || p.TLS == nil {
+		return errors.New("not a TLS connection")
+	}
+	if len(p.TLS.PeerCertificates) > 0 {
+		*reply = p.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return nil
+}
+
+func startTLSServer(ca *testCA, clientAuth tls.ClientAuthType) (*Server, string) {
+	server := NewServer()
+	var w Whoami
+	_ = server.Register(&w)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	_assert(err == nil, "failed to listen: %v", err)
+	go server.AcceptTLS(l, &tls.Config{
+		Certificates: []tls.Certificate{ca.issue("server", x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   clientAuth,
+		ClientCAs:    ca.pool,
+	})
+	return server, l.Addr().String()
+}
+
+// 双向认证时服务方法可以得到客户端证书，没有证书的客户端无法建立连接。
+func TestDialTLS(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA()
+	server, addr := startTLSServer(ca, tls.RequireAndVerifyClientCert)
+	defer func() { _ = server.Close() }()
+
+	config := &tls.Config{
+		RootCAs:      ca.pool,
+		Certificates: []tls.Certificate{ca.issue("alice", x509.ExtKeyUsageClientAuth)},
+	}
+	client, err := DialTLS("tcp", addr, config)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var name string
+	err = client.Call(context.Background(), "Whoami.Name", 0, &name)
+	_assert(err == nil && name == "alice", "expect alice, got %q (%v)", name, err)
+
+	_, err = DialTLS("tcp", addr, &tls.Config{RootCAs: ca.pool}, &Option{ConnectTimeout: time.Second})
+	_assert(err != nil, "expect a client without certificate to be rejected")
+	_, err = Dial("tcp", addr, &Option{ConnectTimeout: time.Second})
+	_assert(err != nil, "expect a plaintext client to be rejected")
+}
+
+// XDial 使用 tls@addr 时从 Option.TLSConfig 中读取 TLS 配置。
+func TestXDial_tls(t *testing.T) {
+	t.Parallel()
+	ca := newTestCA()
+	server, addr := startTLSServer(ca, tls.NoClientCert)
+	defer func() { _ = server.Close() }()
+
+	client, err := XDial("tls@"+addr, &Option{TLSConfig: &tls.Config{RootCAs: ca.pool}})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var name string
+	err = client.Call(context.Background(), "Whoami.Name", 0, &name)
+	_assert(err == nil && name == "", "expect an empty name, got %q (%v)", name, err)
+
+	_, err = XDial("tls@"+addr, &Option{ConnectTimeout: time.Second})
+	_assert(err != nil, "expect the self-signed certificate to be rejected by default")
+}
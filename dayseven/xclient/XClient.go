@@ -6,20 +6,60 @@ import (
 	. "minirpc"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type XClient struct {
 	d Discovery //服务发现实例
 	mode SelectMode //负载均衡模式
+	failMode FailMode //调用失败时的处理方式
 	opt *Option //协议选项
 	mu sync.Mutex
-	clients map[string]*Client //使用 clients 保存创建成功的 Client 实例
+	poolSize int //每个服务实例的最大连接数
+	pools map[string]*Pool //使用 pools 保存每个服务实例的连接池
+	retry *RetryPolicy //Call 的重试策略
+	idempotent map[string]bool //RegisterIdempotent 标记的幂等方法
+	backupLatency time.Duration //Failbackup 发送备份请求之前的等待时间
+	hedge *HedgePolicy //幂等调用的对冲策略
+	hedgeTokens float64 //还可以发出的对冲请求数
+	latency map[string]*latencyHistogram //每个服务实例的延迟直方图
+	breakerConfig *BreakerConfig //断路器的阈值，为 nil 时不启用断路器
+	breakers map[string]*breaker //每个服务实例的断路器
 }
 
 var _ io.Closer = (*XClient)(nil)
 
+//opt.Interceptors 作用于 Call 和 Broadcast 发往每个服务实例的调用。
+//Call 失败时按照 Failfast 处理，只调用一次，需要重试或对冲时使用 NewXClientWithMode。
+//每个服务实例都有一个使用 DefaultBreakerConfig 的断路器，可以通过 SetBreaker 修改。
 func NewXClient(d Discovery,mode SelectMode,opt *Option) *XClient  {
-	return &XClient{d:d,mode: mode,opt: opt,clients: make(map[string]*Client)}
+	return NewXClientWithMode(d,mode,Failfast,opt)
+}
+
+// NewXClientWithMode is like NewXClient but Call handles failures according to failMode.
+func NewXClientWithMode(d Discovery,mode SelectMode,failMode FailMode,opt *Option) *XClient  {
+	config := DefaultBreakerConfig
+	return &XClient{
+		d:d,
+		mode: mode,
+		failMode: failMode,
+		opt: opt,
+		poolSize: DefaultPoolSize,
+		pools: make(map[string]*Pool),
+		idempotent: make(map[string]bool),
+		backupLatency: DefaultBackupLatency,
+		latency: make(map[string]*latencyHistogram),
+		breakerConfig: &config,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// SetPoolSize sets the maximum number of connections to each server.
+//只影响之后新建的连接池。
+func (xc *XClient) SetPoolSize(size int) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.poolSize = size
 }
 
 //提供 Close 方法在结束后，关闭已经建立的连接。
@@ -27,52 +67,81 @@ func NewXClient(d Discovery,mode SelectMode,opt *Option) *XClient  {
 func (xc *XClient) Close()error  {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
-	for key,client := range xc.clients {
-		_=client.Close()
-		delete(xc.clients,key)
+	for key,pool := range xc.pools {
+		_=pool.Close()
+		delete(xc.pools,key)
 	}
 	return nil
 }
 
 //我们将复用 Client 的能力封装在方法 dial 中，dial 的处理逻辑如下：
-//检查 xc.clients 是否有缓存的 Client，如果有，检查是否是可用状态，如果是则返回缓存的 Client，如果不可用，则从缓存中删除。
-//如果步骤 1) 没有返回缓存的 Client，则说明需要创建新的 Client，缓存并返回。
+//检查 xc.pools 是否有该服务实例的连接池，如果没有则创建一个。
+//从连接池中取出等待响应的调用最少的 Client，不可用的 Client 由连接池移除，需要时建立新的连接。
 func (xc *XClient) dial(rpcAddr string) (*Client,error)  {
 	xc.mu.Lock()
-	defer xc.mu.Unlock()
-	client,ok := xc.clients[rpcAddr]
-	if ok && !client.IsAvailable(){
-		_=client.Close()
-		delete(xc.clients,rpcAddr)
-		client=nil
-	}
-	if client == nil {
-		var err error
-		client,err = XDial(rpcAddr,xc.opt)
-		if err != nil {
-			return nil,err
-		}
-		xc.clients[rpcAddr] = client
+	pool,ok := xc.pools[rpcAddr]
+	if !ok {
+		pool = NewPool(rpcAddr,xc.poolSize,xc.opt)
+		xc.pools[rpcAddr] = pool
 	}
-	return client,nil
+	xc.mu.Unlock()
+	return pool.Get()
 }
 // Call invokes the named function, waits for it to complete,
 // and returns its error status.
 // xc will choose a proper server.
+//断路器打开时直接返回 ErrBreakerOpen，否则调用的结果（包括连接失败）计入断路器。
 func (xc *XClient) call(rpcAddr string,ctx context.Context,serviceMethod string,args,reply interface{}) error  {
+	if !xc.allow(rpcAddr) {
+		return ErrBreakerOpen
+	}
 	client,err :=xc.dial(rpcAddr)
 	if err != nil {
+		xc.report(rpcAddr,ctx,err)
 		return err
 	}
-	return client.Call(ctx,serviceMethod,args,reply)
+	start := time.Now()
+	err = client.Call(ctx,serviceMethod,args,reply)
+	xc.observe(rpcAddr,start,err)
+	xc.report(rpcAddr,ctx,err)
+	return err
 }
 
+//调用失败时的处理方式由 FailMode 决定：Failover 和 Failtry 按照 SetRetryPolicy 设置的重试策略重试，
+//没有设置重试策略时，Failover 只在服务端正在关闭、请求没有被处理时依次换其他服务实例重试。
 func (xc *XClient) Call(ctx context.Context,serviceMethod string,args,reply interface{}) error {
-	rpcAddr,err :=xc.d.Get(xc.mode)
-	if err != nil {
-		return err
+	xc.mu.Lock()
+	policy,hedge,latency,failMode := xc.retry,xc.hedge,xc.backupLatency,xc.failMode
+	xc.mu.Unlock()
+	idempotent := xc.isIdempotent(ctx,serviceMethod)
+	if failMode == Failbackup {
+		return xc.hedged(ctx,serviceMethod,args,reply,func(string) time.Duration { return latency },nil)
+	}
+	//幂等的调用在设置了对冲策略时发送对冲请求，Failfast 只发送一次请求
+	if hedge != nil && idempotent && failMode != Failfast {
+		xc.chargeHedge(hedge)
+		delay := func(rpcAddr string) time.Duration { return xc.hedgeDelay(hedge,rpcAddr) }
+		return xc.hedged(ctx,serviceMethod,args,reply,delay,xc.allowHedge)
+	}
+	r := xc.newRetrier(failMode,policy,idempotent)
+	var err error
+	for {
+		rpcAddr,e := r.next(xc)
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			return err
+		}
+		r.tried[rpcAddr] = true
+		r.last = rpcAddr
+		r.attempts++
+		var sent bool
+		sent,err = xc.attempt(rpcAddr,ctx,policy,serviceMethod,args,reply)
+		if err == nil || !r.shouldRetry(err,sent) || !r.wait(ctx) {
+			return err
+		}
 	}
-	return xc.call(rpcAddr,ctx,serviceMethod,args,reply)
 }
 
 // Broadcast invokes the named function for every server registered in discovery
@@ -91,14 +160,12 @@ func (xc *XClient) Broadcast(ctx context.Context,serviceMethod string,args,reply
 	var e error
 	replyDone := reply==nil
 	ctx,cancel := context.WithCancel(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers{
 		wg.Add(1)
 		go func(rpcAddr string) {
 			defer wg.Done()
-			var clonedReply interface{}
-			if reply!=nil {
-				clonedReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
-			}
+			clonedReply := cloneReply(reply)
 			err := xc.call(rpcAddr,ctx,serviceMethod,args,clonedReply)
 			mu.Lock()
 			if err!=nil && e == nil {
@@ -114,4 +181,36 @@ func (xc *XClient) Broadcast(ctx context.Context,serviceMethod string,args,reply
 	}
 	wg.Wait()
 	return e
-}
\ No newline at end of file
+}
+// Notify sends a one-way call to a server chosen by the select mode.
+func (xc *XClient) Notify(serviceMethod string,args interface{}) error {
+	rpcAddr,err :=xc.get()
+	if err != nil {
+		return err
+	}
+	client,err :=xc.dial(rpcAddr)
+	if err != nil {
+		return err
+	}
+	return client.Notify(serviceMethod,args)
+}
+
+// BroadcastNotify sends a one-way call to every server registered in discovery.
+//单向调用不等待服务端处理，发送给所有实例之后返回其中一个发送错误。
+func (xc *XClient) BroadcastNotify(serviceMethod string,args interface{}) error {
+	servers,err :=xc.d.GetAll()
+	if err != nil {
+		return err
+	}
+	var e error
+	for _,rpcAddr :=range servers {
+		client,err :=xc.dial(rpcAddr)
+		if err == nil {
+			err = client.Notify(serviceMethod,args)
+		}
+		if err != nil && e == nil {
+			e = err
+		}
+	}
+	return e
+}
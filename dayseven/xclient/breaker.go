@@ -0,0 +1,331 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen is returned for calls to a server whose circuit breaker is open.
+// 请求没有发出，可以换一个服务实例重试。
+var ErrBreakerOpen = errors.New("rpc xclient: circuit breaker is open")
+
+// BreakerState is the state of a server's circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常调用
+	BreakerOpen                         // 服务实例被认为不可用，调用直接失败，服务发现时跳过它
+	BreakerHalfOpen                     // 打开超过 OpenTimeout 之后，允许少量试探调用，成功则关闭，失败则重新打开
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// BreakerConfig configures the circuit breaker kept for every server.
+// 连续失败次数达到 ConsecutiveFailures，或者滑动窗口 Window 内的错误率达到 ErrorRate 时断路器打开，
+// 两个条件中为 0 的不生效。只有 IsFailure 认为是服务实例故障的错误才计入失败，服务方法返回的错误不算。
+type BreakerConfig struct {
+	ConsecutiveFailures int                  // 连续失败的次数阈值
+	ErrorRate           float64              // 滑动窗口内的错误率阈值，0 到 1 之间
+	Window              time.Duration        // 滑动窗口的长度
+	MinRequests         int                  // 窗口内的调用少于它时不按错误率判断
+	OpenTimeout         time.Duration        // 打开之后经过多久进入半开状态
+	HalfOpenRequests    int                  // 半开状态下同时允许的试探调用数
+	IsFailure           func(err error) bool // 为 nil 时使用 IsRetryable
+}
+
+// DefaultBreakerConfig is used by NewXClient.
+var DefaultBreakerConfig = BreakerConfig{
+	ConsecutiveFailures: 5,
+	ErrorRate:           0.5,
+	Window:              10 * time.Second,
+	MinRequests:         20,
+	OpenTimeout:         5 * time.Second,
+	HalfOpenRequests:    1,
+}
+
+// SetBreaker sets the circuit breaker thresholds for every server, nil disables circuit breaking.
+// 已有的断路器状态被丢弃。
+func (xc *XClient) SetBreaker(config *BreakerConfig) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.breakerConfig = config
+	xc.breakers = make(map[string]*breaker)
+}
+
+// breaker 返回 rpcAddr 的断路器，没有启用断路器时返回 nil。
+func (xc *XClient) breaker(rpcAddr string) *breaker {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.breakerConfig == nil {
+		return nil
+	}
+	b, ok := xc.breakers[rpcAddr]
+	if !ok {
+		b = newBreaker(*xc.breakerConfig)
+		xc.breakers[rpcAddr] = b
+	}
+	return b
+}
+
+// available 报告 rpcAddr 当前是否可以被选中。
+func (xc *XClient) available(rpcAddr string) bool {
+	b := xc.breaker(rpcAddr)
+	return b == nil || b.available(time.Now())
+}
+
+// allow 在向 rpcAddr 发出调用之前调用，返回 false 时调用应该以 ErrBreakerOpen 失败，
+// 返回 true 时需要在调用结束之后调用 report。
+func (xc *XClient) allow(rpcAddr string) bool {
+	b := xc.breaker(rpcAddr)
+	return b == nil || b.allow(time.Now())
+}
+
+// report 记录一次使用 ctx 发往 rpcAddr 的调用的结果。
+// 因为 ctx 被取消而失败的调用（调用方放弃、对冲请求中落后的一个）不能说明服务实例的状况，不计入断路器，
+// 只归还半开状态下的试探名额；超过 ctx 截止时间的调用说明服务实例没有及时响应，计为一次失败。
+func (xc *XClient) report(rpcAddr string, ctx context.Context, err error) {
+	b := xc.breaker(rpcAddr)
+	if b == nil {
+		return
+	}
+	if err != nil {
+		switch ctx.Err() {
+		case context.Canceled:
+			b.release()
+			return
+		case context.DeadlineExceeded:
+			err = ErrAttemptTimeout
+		}
+	}
+	b.record(time.Now(), err)
+}
+
+// get 代替 Discovery.Get，按负载均衡策略选择一个断路器没有打开的服务实例，
+// 这样故障的实例不会一直被选中，每次调用都等待 ConnectTimeout。
+func (xc *XClient) get() (string, error) {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	rpcAddr, err := xc.d.Get(xc.mode)
+	if err != nil || xc.available(rpcAddr) {
+		return rpcAddr, err
+	}
+	for i := 1; i < len(servers); i++ {
+		if rpcAddr, err = xc.d.Get(xc.mode); err == nil && xc.available(rpcAddr) {
+			return rpcAddr, nil
+		}
+	}
+	// 随机选择时可能一直没有选中可用的实例，最后按顺序查找一遍
+	for _, addr := range servers {
+		if xc.available(addr) {
+			return addr, nil
+		}
+	}
+	return "", ErrBreakerOpen
+}
+
+// windowBuckets 是滑动窗口划分的桶数，过期的桶整个丢弃。
+const windowBuckets = 10
+
+type windowBucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+type breaker struct {
+	config BreakerConfig
+
+	mu          sync.Mutex // protect following
+	state       BreakerState
+	consecutive int       // 连续失败的次数
+	openedAt    time.Time // 最近一次打开的时间
+	probes      int       // 半开状态下正在进行的试探调用数
+	buckets     [windowBuckets]windowBucket
+}
+
+func newBreaker(config BreakerConfig) *breaker {
+	if config.IsFailure == nil {
+		config.IsFailure = IsRetryable
+	}
+	if config.HalfOpenRequests <= 0 {
+		config.HalfOpenRequests = 1
+	}
+	return &breaker{config: config}
+}
+
+// refreshLocked 在打开超过 OpenTimeout 之后切换到半开状态。
+func (b *breaker) refreshLocked(now time.Time) {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.config.OpenTimeout {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+	}
+}
+
+func (b *breaker) available(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked(now)
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.probes < b.config.HalfOpenRequests
+	}
+	return true
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refreshLocked(now)
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.probes >= b.config.HalfOpenRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// release 归还一次没有结果的调用占用的试探名额。
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen && b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *breaker) record(now time.Time, err error) {
+	failed := err != nil && b.config.IsFailure(err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bucket := b.bucketLocked(now)
+	bucket.requests++
+	if failed {
+		bucket.failures++
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+	switch b.state {
+	case BreakerHalfOpen:
+		// 试探调用的结果决定断路器关闭还是重新打开，打开之前发出的调用也可能在此时返回
+		if b.probes > 0 {
+			b.probes--
+		}
+		if failed {
+			b.openLocked(now)
+		} else {
+			b.state = BreakerClosed
+			b.buckets = [windowBuckets]windowBucket{}
+		}
+	case BreakerClosed:
+		if failed && b.shouldTripLocked(now) {
+			b.openLocked(now)
+		}
+	}
+}
+
+func (b *breaker) openLocked(now time.Time) {
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.probes = 0
+}
+
+func (b *breaker) shouldTripLocked(now time.Time) bool {
+	if b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures {
+		return true
+	}
+	if b.config.ErrorRate <= 0 {
+		return false
+	}
+	requests, failures := b.countLocked(now)
+	return requests > 0 && requests >= b.config.MinRequests &&
+		float64(failures)/float64(requests) >= b.config.ErrorRate
+}
+
+// bucketLocked 返回 now 所在的桶，桶中是上一轮的数据时先清空。
+func (b *breaker) bucketLocked(now time.Time) *windowBucket {
+	width := b.bucketWidth()
+	start := now.Truncate(width)
+	bucket := &b.buckets[int(start.UnixNano()/int64(width))%windowBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = windowBucket{start: start}
+	}
+	return bucket
+}
+
+// countLocked 统计滑动窗口内的调用数和失败数。
+func (b *breaker) countLocked(now time.Time) (requests, failures int) {
+	width := b.bucketWidth()
+	for _, bucket := range b.buckets {
+		if now.Sub(bucket.start) < width*windowBuckets {
+			requests += bucket.requests
+			failures += bucket.failures
+		}
+	}
+	return
+}
+
+func (b *breaker) bucketWidth() time.Duration {
+	width := b.config.Window / windowBuckets
+	if width <= 0 {
+		width = time.Millisecond
+	}
+	return width
+}
+
+// BreakerStats is a snapshot of one server's circuit breaker.
+type BreakerStats struct {
+	Addr                string
+	State               BreakerState
+	ConsecutiveFailures int
+	Requests            int // 滑动窗口内的调用数
+	Failures            int // 滑动窗口内的失败数
+}
+
+// Breakers returns the state of every server's circuit breaker, ordered by address.
+func (xc *XClient) Breakers() []BreakerStats {
+	xc.mu.Lock()
+	breakers := make(map[string]*breaker, len(xc.breakers))
+	for addr, b := range xc.breakers {
+		breakers[addr] = b
+	}
+	xc.mu.Unlock()
+	now := time.Now()
+	stats := make([]BreakerStats, 0, len(breakers))
+	for addr, b := range breakers {
+		b.mu.Lock()
+		b.refreshLocked(now)
+		requests, failures := b.countLocked(now)
+		stats = append(stats, BreakerStats{
+			Addr:                addr,
+			State:               b.state,
+			ConsecutiveFailures: b.consecutive,
+			Requests:            requests,
+			Failures:            failures,
+		})
+		b.mu.Unlock()
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Addr < stats[j].Addr })
+	return stats
+}
@@ -0,0 +1,162 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "minirpc"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBreaker_consecutiveFailures(t *testing.T) {
+	b := newBreaker(BreakerConfig{ConsecutiveFailures: 3, OpenTimeout: time.Minute, HalfOpenRequests: 1})
+	now := time.Now()
+	lost := ErrUnavailable
+	b.record(now, lost)
+	b.record(now, lost)
+	b.record(now, errors.New("application error"))
+	b.record(now, lost)
+	b.record(now, lost)
+	_assert(b.available(now), "application errors should reset the consecutive failures")
+	b.record(now, lost)
+	_assert(!b.available(now) && !b.allow(now), "expect the breaker to open")
+
+	// 经过 OpenTimeout 之后半开，只允许一个试探调用
+	now = now.Add(time.Minute)
+	_assert(b.allow(now), "expect a probe after OpenTimeout")
+	_assert(!b.available(now) && !b.allow(now), "only one probe at a time")
+	b.record(now, lost)
+	_assert(!b.available(now), "a failed probe should reopen the breaker")
+	now = now.Add(time.Minute)
+	_assert(b.allow(now), "expect another probe")
+	b.record(now, nil)
+	_assert(b.state == BreakerClosed && b.allow(now) && b.allow(now), "a successful probe should close the breaker")
+}
+
+func TestBreaker_errorRate(t *testing.T) {
+	b := newBreaker(BreakerConfig{ErrorRate: 0.5, MinRequests: 6, Window: time.Second, OpenTimeout: time.Minute})
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		b.record(now, nil)
+	}
+	b.record(now, ErrUnavailable)
+	_assert(b.available(now), "too few requests to trip")
+	b.record(now, ErrUnavailable)
+	_assert(b.available(now), "an error rate of 1/3 shouldn't trip")
+
+	// 窗口滑过之后旧的调用不再计入
+	now = now.Add(2 * time.Second)
+	b.record(now, nil)
+	for i := 0; i < 4; i++ {
+		b.record(now, ErrUnavailable)
+	}
+	_assert(b.available(now), "too few requests in the window to trip")
+	b.record(now, ErrUnavailable)
+	_assert(!b.available(now), "an error rate of 5/6 should trip")
+}
+
+// 断路器打开之后，服务发现不再选中故障的服务实例，状态显示在调试页面上。
+func TestXClient_breaker(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(1)
+	defer func() { _ = servers[0].Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	dead := "tcp@" + l.Addr().String()
+	_ = l.Close()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{dead, addrs[0]}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(&BreakerConfig{ConsecutiveFailures: 2, OpenTimeout: time.Hour})
+	var reply int
+	failed := 0
+	for i := 0; i < 10; i++ {
+		err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+		if err != nil {
+			_assert(!errors.Is(err, ErrBreakerOpen), "an open breaker should be skipped, got %v", err)
+			failed++
+		}
+	}
+	_assert(failed == 2, "expect only 2 calls to the dead server, got %d", failed)
+
+	stats := xc.Breakers()
+	_assert(len(stats) == 2, "expect 2 breakers, got %d", len(stats))
+	for _, s := range stats {
+		want := BreakerClosed
+		if s.Addr == dead {
+			want = BreakerOpen
+		}
+		_assert(s.State == want, "expect %s to be %s, got %s", s.Addr, want, s.State)
+	}
+
+	w := httptest.NewRecorder()
+	xc.ServeHTTP(w, httptest.NewRequest("GET", "/debug/xclient", nil))
+	body := w.Body.String()
+	_assert(strings.Contains(body, dead) && strings.Contains(body, "open"), "the debug page should show the open breaker:\n%s", body)
+}
+
+// 服务实例收到请求之后不再响应时，超过调用方截止时间的调用计为失败。
+func TestXClient_breakerDeadline(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(1)
+	defer func() { _ = servers[0].Close() }()
+	servers[0].Use(func(ctx context.Context, info *CallInfo, next Handler) error {
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+		}
+		return ctx.Err()
+	})
+
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(&BreakerConfig{ConsecutiveFailures: 3, OpenTimeout: time.Hour})
+	var reply int
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := xc.Call(ctx, "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+		cancel()
+		_assert(err != nil && !errors.Is(err, ErrBreakerOpen), "call %d should time out, got %v", i, err)
+	}
+	err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 1}, &reply)
+	_assert(errors.Is(err, ErrBreakerOpen), "expect the breaker to open, got %v", err)
+}
+
+// 对冲请求中被取消的一个不计入断路器，半开状态的断路器不会因此关闭。
+func TestXClient_breakerHedgeLoser(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startSlowServers()
+	defer func() {
+		for _, s := range servers {
+			_ = s.Close()
+		}
+	}()
+	d := NewMultiServerDiscovery(addrs)
+	d.index = 0 // 第一个请求发往响应慢的 addrs[0]
+	xc := NewXClientWithMode(d, RoundRobinSelect, Failbackup, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBreaker(&BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Hour})
+	xc.SetBackupLatency(20 * time.Millisecond)
+	b := xc.breaker(addrs[0])
+	b.mu.Lock()
+	b.state = BreakerHalfOpen
+	b.mu.Unlock()
+
+	var reply int
+	err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: 1, Num2: 1}, &reply)
+	_assert(err == nil && reply == 2, "expect 2, got %d (%v)", reply, err)
+	// 落后的请求在 Call 返回之后才结束
+	deadline := time.Now().Add(time.Second)
+	for {
+		b.mu.Lock()
+		state, probes := b.state, b.probes
+		b.mu.Unlock()
+		if state != BreakerHalfOpen || probes == 0 || time.Now().After(deadline) {
+			_assert(state == BreakerHalfOpen && probes == 0, "expect a free half-open probe, got %s with %d probes", state, probes)
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
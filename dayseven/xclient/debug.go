@@ -0,0 +1,63 @@
+package xclient
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+const debugText = `<html>
+	<body>
+	<title>GeeRPC XClient</title>
+	<hr>
+	Servers
+	<hr>
+		<table>
+		<th align=center>Server</th><th align=center>Breaker</th><th align=center>Consecutive Failures</th><th align=center>Requests</th><th align=center>Failures</th><th align=center>p50</th><th align=center>p99</th>
+		{{range .}}
+			<tr>
+			<td align=left font=fixed>{{.Addr}}</td>
+			<td align=center>{{.State}}</td>
+			<td align=center>{{.ConsecutiveFailures}}</td>
+			<td align=center>{{.Requests}}</td>
+			<td align=center>{{.Failures}}</td>
+			<td align=center>{{.P50}}</td>
+			<td align=center>{{.P99}}</td>
+			</tr>
+		{{end}}
+		</table>
+	</body>
+	</html>`
+
+var debug = template.Must(template.New("XClient debug").Parse(debugText))
+
+type debugServer struct {
+	BreakerStats
+	P50, P99 string
+}
+
+// ServeHTTP shows the circuit breaker state and latency of every server.
+// 请求数和失败数统计的是断路器滑动窗口内的调用，延迟样本不足时显示为 -。
+func (xc *XClient) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	stats := xc.Breakers()
+	servers := make([]debugServer, 0, len(stats))
+	for _, s := range stats {
+		servers = append(servers, debugServer{
+			BreakerStats: s,
+			P50:          xc.formatLatency(s.Addr, 0.5),
+			P99:          xc.formatLatency(s.Addr, 0.99),
+		})
+	}
+	if err := debug.Execute(w, servers); err != nil {
+		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
+	}
+}
+
+func (xc *XClient) formatLatency(rpcAddr string, p float64) string {
+	d, ok := xc.Latency(rpcAddr, p)
+	if !ok {
+		return "-"
+	}
+	return "≤" + d.Round(time.Microsecond).String()
+}
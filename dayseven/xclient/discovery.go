@@ -50,7 +50,7 @@ func NewMultiServerDiscovery(servers []string) *MultiServersDiscovery  {
 
 var _ Discovery = (*MultiServersDiscovery)(nil)
 
-func (d MultiServersDiscovery) Refresh() error {
+func (d *MultiServersDiscovery) Refresh() error {
 	return nil
 }
 
@@ -0,0 +1,55 @@
+package xclient
+
+import (
+	"reflect"
+	"time"
+)
+
+// FailMode decides what XClient.Call does when a call fails.
+type FailMode int
+
+const (
+	Failfast   FailMode = iota // 调用失败时立即返回错误，不重试，也不发送对冲请求
+	Failover                   // 按照重试策略换其他服务实例重试，没有重试策略时只重试没有发出的请求
+	Failtry                    // 按照重试策略在同一个服务实例上重试，没有重试策略时只重试没有发出的请求
+	Failbackup                 // 第一个服务实例在 BackupLatency 内没有响应时，向另一个服务实例发送相同的请求，取最先返回的结果
+)
+
+func (m FailMode) String() string {
+	switch m {
+	case Failfast:
+		return "failfast"
+	case Failover:
+		return "failover"
+	case Failtry:
+		return "failtry"
+	case Failbackup:
+		return "failbackup"
+	}
+	return "unknown"
+}
+
+// SetFailMode sets what Call does when a call fails, overriding the mode given at construction.
+func (xc *XClient) SetFailMode(mode FailMode) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.failMode = mode
+}
+
+// DefaultBackupLatency is how long Failbackup waits before sending the backup request.
+const DefaultBackupLatency = 10 * time.Millisecond
+
+// SetBackupLatency sets how long Failbackup waits for the first server before sending the backup request.
+func (xc *XClient) SetBackupLatency(d time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.backupLatency = d
+}
+
+// cloneReply 创建一个与 reply 类型相同的新实例，并发的调用各自写入自己的 reply。
+func cloneReply(reply interface{}) interface{} {
+	if reply == nil {
+		return nil
+	}
+	return reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+}
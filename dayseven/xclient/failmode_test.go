@@ -0,0 +1,98 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "minirpc"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// NewXClient 默认使用 Failfast，不重试，即使设置了重试策略。
+func TestXClient_failfast(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(1)
+	defer func() { _ = servers[0].Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	dead := "tcp@" + l.Addr().String()
+	_ = l.Close()
+
+	xc := NewXClient(NewMultiServerDiscovery([]string{dead, addrs[0]}), RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3})
+	var reply int
+	failed := 0
+	for i := 0; i < 4; i++ {
+		if xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply) != nil {
+			failed++
+		}
+	}
+	_assert(failed == 2, "expect every call to the dead server to fail, got %d failures", failed)
+}
+
+// Failtry 在同一个服务实例上重试。
+func TestXClient_failtry(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(3)
+	defer func() {
+		for _, s := range servers {
+			_ = s.Close()
+		}
+	}()
+	attempts := recordAttempts(servers)
+	xc := NewXClientWithMode(NewMultiServerDiscovery(addrs), RandomSelect, Failtry, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetRetryPolicy(&RetryPolicy{
+		MaxAttempts:       3,
+		PerAttemptTimeout: 30 * time.Millisecond,
+		Backoff:           Backoff{BaseDelay: time.Millisecond},
+	})
+
+	var reply int
+	err := xc.Call(WithIdempotent(context.Background()), "Foo.Sleep", 200, &reply)
+	_assert(errors.Is(err, ErrAttemptTimeout), "expect ErrAttemptTimeout, got %v", err)
+	tried := attempts()
+	_assert(len(tried) == 3 && tried[0] == tried[1] && tried[1] == tried[2], "expect 3 attempts on the same server, got %v", tried)
+}
+
+// Failbackup 在第一个服务实例响应慢时向另一个实例发送相同的请求，取最先返回的结果并取消另一个请求。
+func TestXClient_failbackup(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(2)
+	defer func() {
+		for _, s := range servers {
+			_ = s.Close()
+		}
+	}()
+	// 第一个服务实例处理每个请求都很慢，直到请求被取消
+	var slow, canceled int32
+	servers[0].Use(func(ctx context.Context, info *CallInfo, next Handler) error {
+		atomic.AddInt32(&slow, 1)
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			atomic.AddInt32(&canceled, 1)
+			return ctx.Err()
+		}
+		return next(ctx, info)
+	})
+	xc := NewXClientWithMode(NewMultiServerDiscovery(addrs), RoundRobinSelect, Failbackup, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetBackupLatency(20 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		var reply int
+		start := time.Now()
+		err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "call %d failed: %v", i, err)
+		_assert(time.Since(start) < 500*time.Millisecond, "the backup request should win, took %s", time.Since(start))
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&canceled) < atomic.LoadInt32(&slow) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	n, c := atomic.LoadInt32(&slow), atomic.LoadInt32(&canceled)
+	_assert(n > 0 && c == n, "all %d slow requests should be canceled, got %d", n, c)
+}
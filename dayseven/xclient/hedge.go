@@ -0,0 +1,209 @@
+package xclient
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// HedgePolicy controls hedged requests for idempotent calls.
+// 幂等的（只读的）调用在第一个服务实例超过它的 Percentile 分位延迟仍未响应时，向另一个实例发送相同的请求，
+// 采用最先返回的结果并取消另一个请求。MaxRate 限制对冲请求占调用总数的比例，避免整体变慢时请求量翻倍。
+type HedgePolicy struct {
+	Percentile float64       // 例如 0.95，取服务实例延迟直方图的这个分位数作为等待时间
+	MinDelay   time.Duration // 等待时间的下限，样本不足时也使用它
+	MaxRate    float64       // 对冲请求数与调用数之比的上限，例如 0.1
+}
+
+// maxHedgeTokens 是对冲请求额度的上限，允许短时间内集中发出少量对冲请求。
+const maxHedgeTokens = 10
+
+// SetHedgePolicy enables hedged requests for idempotent calls, nil disables them.
+// 对冲的调用不再按照重试策略重试；Failfast 模式下不发送对冲请求，Failbackup 模式下所有调用都使用固定的 BackupLatency，不受它影响。
+func (xc *XClient) SetHedgePolicy(policy *HedgePolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.hedge = policy
+}
+
+// hedgeDelay 返回向 rpcAddr 发出调用之后，发送对冲请求之前的等待时间。
+func (xc *XClient) hedgeDelay(policy *HedgePolicy, rpcAddr string) time.Duration {
+	d, ok := xc.Latency(rpcAddr, policy.Percentile)
+	if !ok || d < policy.MinDelay {
+		return policy.MinDelay
+	}
+	return d
+}
+
+// allowHedge 消耗一个对冲请求的额度，额度用完时返回 false。
+func (xc *XClient) allowHedge() bool {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if xc.hedgeTokens < 1 {
+		return false
+	}
+	xc.hedgeTokens--
+	return true
+}
+
+// chargeHedge 在每次对冲的调用之前按照 MaxRate 增加额度。
+func (xc *XClient) chargeHedge(policy *HedgePolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.hedgeTokens = math.Min(xc.hedgeTokens+policy.MaxRate, maxHedgeTokens)
+}
+
+// hedged 向选出的服务实例发出调用，delay 之后仍未返回并且 allow 允许时，向另一个服务实例发送相同的请求，
+// 采用最先成功返回的结果，并取消另一个请求。两个请求都失败时返回第一个错误，
+// 第一个请求在对冲请求发出之前失败时直接返回它的错误。服务方法可能因此被执行两次。
+func (xc *XClient) hedged(ctx context.Context, serviceMethod string, args, reply interface{},
+	delay func(rpcAddr string) time.Duration, allow func() bool) error {
+	rpcAddr, err := xc.get()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	results := make(chan result, 2)
+	launch := func(rpcAddr string) {
+		clonedReply := cloneReply(reply)
+		go func() {
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, clonedReply)
+			results <- result{clonedReply, err}
+		}()
+	}
+	launch(rpcAddr)
+	inflight := 1
+	timer := time.NewTimer(delay(rpcAddr))
+	defer timer.Stop()
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if addr := xc.another(rpcAddr); addr != "" && (allow == nil || allow()) {
+				launch(addr)
+				inflight++
+			}
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.reply).Elem())
+				}
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if inflight == 0 {
+				return firstErr
+			}
+		}
+	}
+}
+
+// another 返回 rpcAddr 之外的一个断路器没有打开的服务实例，没有其他实例时返回空字符串。
+func (xc *XClient) another(rpcAddr string) string {
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return ""
+	}
+	for i := 0; i < len(servers); i++ {
+		addr, err := xc.d.Get(xc.mode)
+		if err != nil {
+			return ""
+		}
+		if addr != rpcAddr && xc.available(addr) {
+			return addr
+		}
+	}
+	for _, addr := range servers {
+		if addr != rpcAddr && xc.available(addr) {
+			return addr
+		}
+	}
+	return ""
+}
+
+const (
+	numLatencyBuckets = 24 // 第 i 个桶的上界是 minLatencyBucket<<i，最后一个桶约 14 分钟
+	minLatencyBucket  = 100 * time.Microsecond
+	minLatencySamples = 10   // 样本少于它时不计算分位数
+	maxLatencySamples = 1000 // 样本达到它时所有计数减半，让直方图逐渐反映最近的延迟
+)
+
+// latencyHistogram 是一个服务实例上成功调用的延迟分布，桶按指数增长。
+type latencyHistogram struct {
+	mu     sync.Mutex
+	counts [numLatencyBuckets]uint64
+	total  uint64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < numLatencyBuckets-1 && d > minLatencyBucket<<i {
+		i++
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.total++
+	if h.total >= maxLatencySamples {
+		h.total = 0
+		for i := range h.counts {
+			h.counts[i] /= 2
+			h.total += h.counts[i]
+		}
+	}
+}
+
+// percentile 返回 p 分位数所在桶的上界，样本不足时返回 false。
+func (h *latencyHistogram) percentile(p float64) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.total < minLatencySamples {
+		return 0, false
+	}
+	target := uint64(math.Ceil(p * float64(h.total)))
+	var n uint64
+	for i, c := range h.counts {
+		n += c
+		if n >= target {
+			return minLatencyBucket << i, true
+		}
+	}
+	return minLatencyBucket << (numLatencyBuckets - 1), true
+}
+
+// observe 记录一次发往 rpcAddr 的调用的延迟，只记录成功的调用。
+func (xc *XClient) observe(rpcAddr string, start time.Time, err error) {
+	if err != nil {
+		return
+	}
+	xc.mu.Lock()
+	h, ok := xc.latency[rpcAddr]
+	if !ok {
+		h = new(latencyHistogram)
+		xc.latency[rpcAddr] = h
+	}
+	xc.mu.Unlock()
+	h.observe(time.Since(start))
+}
+
+// Latency returns the p-th percentile (0 < p <= 1) of successful call latencies to rpcAddr,
+// as the upper bound of its histogram bucket. It returns false if there are too few samples.
+func (xc *XClient) Latency(rpcAddr string, p float64) (time.Duration, bool) {
+	xc.mu.Lock()
+	h, ok := xc.latency[rpcAddr]
+	xc.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	return h.percentile(p)
+}
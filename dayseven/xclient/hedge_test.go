@@ -0,0 +1,105 @@
+package xclient
+
+import (
+	"context"
+	. "minirpc"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogram(t *testing.T) {
+	var h latencyHistogram
+	_, ok := h.percentile(0.5)
+	_assert(!ok, "expect no percentile without samples")
+	for i := 0; i < 90; i++ {
+		h.observe(time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.observe(100 * time.Millisecond)
+	}
+	p50, _ := h.percentile(0.5)
+	p99, _ := h.percentile(0.99)
+	_assert(p50 >= time.Millisecond && p50 < 2*time.Millisecond, "unexpected p50 %s", p50)
+	_assert(p99 >= 100*time.Millisecond && p99 < 200*time.Millisecond, "unexpected p99 %s", p99)
+	for i := 0; i < 2*maxLatencySamples; i++ {
+		h.observe(100 * time.Millisecond)
+	}
+	p50, _ = h.percentile(0.5)
+	_assert(p50 >= 100*time.Millisecond, "the histogram should follow recent latencies, got p50 %s", p50)
+}
+
+// startSlowServers 启动两个服务实例，第一个在请求被取消之前不会处理它。
+func startSlowServers() ([]string, []*Server) {
+	addrs, servers := startServers(2)
+	servers[0].Use(func(ctx context.Context, info *CallInfo, next Handler) error {
+		select {
+		case <-time.After(300 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return next(ctx, info)
+	})
+	return addrs, servers
+}
+
+// 幂等的调用在第一个服务实例响应慢时发送对冲请求，非幂等的调用不会。
+func TestXClient_hedge(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startSlowServers()
+	defer func() {
+		for _, s := range servers {
+			_ = s.Close()
+		}
+	}()
+	xc := NewXClientWithMode(NewMultiServerDiscovery(addrs), RoundRobinSelect, Failover, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetHedgePolicy(&HedgePolicy{Percentile: 0.9, MinDelay: 20 * time.Millisecond, MaxRate: 1})
+
+	slowest := func(ctx context.Context) time.Duration {
+		var max time.Duration
+		for i := 0; i < 4; i++ {
+			var reply int
+			start := time.Now()
+			err := xc.Call(ctx, "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+			_assert(err == nil && reply == i+1, "call %d failed: %v", i, err)
+			if d := time.Since(start); d > max {
+				max = d
+			}
+		}
+		return max
+	}
+	_assert(slowest(context.Background()) >= 250*time.Millisecond, "non-idempotent calls shouldn't be hedged")
+	d := slowest(WithIdempotent(context.Background()))
+	_assert(d < 200*time.Millisecond, "idempotent calls should be hedged, the slowest took %s", d)
+	_, ok := xc.Latency(addrs[1], 0.9)
+	_assert(!ok, "too few samples for a percentile")
+	xc.SetFailMode(Failfast)
+	_assert(slowest(WithIdempotent(context.Background())) >= 250*time.Millisecond, "Failfast calls shouldn't be hedged")
+}
+
+// 对冲请求的额度用完之后不再发送对冲请求。
+func TestXClient_hedgeRate(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startSlowServers()
+	defer func() {
+		for _, s := range servers {
+			_ = s.Close()
+		}
+	}()
+	xc := NewXClientWithMode(NewMultiServerDiscovery(addrs), RoundRobinSelect, Failover, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetHedgePolicy(&HedgePolicy{Percentile: 0.9, MinDelay: 20 * time.Millisecond, MaxRate: 0.01})
+	xc.RegisterIdempotent("Foo.Sum")
+
+	var max time.Duration
+	for i := 0; i < 4; i++ {
+		var reply int
+		start := time.Now()
+		err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "call %d failed: %v", i, err)
+		if d := time.Since(start); d > max {
+			max = d
+		}
+	}
+	_assert(max >= 250*time.Millisecond, "hedges over the rate cap shouldn't be sent")
+}
@@ -0,0 +1,159 @@
+package xclient
+
+import (
+	"errors"
+	. "minirpc"
+	"sync"
+)
+
+// DefaultPoolSize 是 XClient 对每个服务实例最多建立的连接数。
+const DefaultPoolSize = 4
+
+// ErrPoolClosed is returned by Get after the pool is closed.
+var ErrPoolClosed = errors.New("rpc pool: pool is closed")
+
+// Pool 保存到同一个服务实例的多个 Client。
+// 一个 Client 上的请求在 sending 锁上串行写出，请求较多时分散到多个连接上可以提高吞吐量。
+// Get 返回等待响应的调用最少的连接，所有连接都有调用在等待、连接数还没有达到上限时在后台建立新的连接，
+// 已经断开或者服务端正在关闭的连接会被移出连接池，等它上面的调用都返回之后再关闭。
+type Pool struct {
+	rpcAddr string
+	opt     *Option
+	size    int
+
+	mu      sync.Mutex // protect following
+	clients []*Client
+	retired []*Client  // 已经移出连接池、还有调用在等待响应的连接
+	dialing int        // 正在建立的连接数，计入连接数上限
+	dialed  *sync.Cond // 一个连接建立完成或者失败时通知等待的 Get
+	closed  bool
+}
+
+// NewPool returns a pool of at most size connections to rpcAddr.
+// rpcAddr 的格式与 XDial 相同，size 不大于 0 时使用 DefaultPoolSize。
+func NewPool(rpcAddr string, size int, opt *Option) *Pool {
+	if size <= 0 {
+		size = DefaultPoolSize
+	}
+	p := &Pool{rpcAddr: rpcAddr, opt: opt, size: size}
+	p.dialed = sync.NewCond(&p.mu)
+	return p
+}
+
+// Get returns the connection with the fewest pending calls.
+// 只有连接池中没有可用的连接时才等待建立连接，否则新的连接在后台建立，调用不必等待 ConnectTimeout。
+func (p *Pool) Get() (*Client, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		p.evictLocked()
+		best := p.leastPendingLocked()
+		full := len(p.clients)+p.dialing >= p.size
+		if best != nil {
+			if best.NumPending() > 0 && !full {
+				p.dialing++
+				go p.grow()
+			}
+			p.mu.Unlock()
+			return best, nil
+		}
+		if p.dialing == 0 {
+			break
+		}
+		// 已经有连接在建立中，等待它完成
+		p.dialed.Wait()
+	}
+	// 建立连接可能需要 ConnectTimeout，期间不持有锁
+	p.dialing++
+	p.mu.Unlock()
+	return p.dial()
+}
+
+// grow 在后台建立一个新的连接，失败时只是不增加连接，下一次 Get 会重新尝试。
+func (p *Pool) grow() {
+	_, _ = p.dial()
+}
+
+// dial 建立一个新的连接并放入连接池，调用方已经增加了 p.dialing。
+func (p *Pool) dial() (*Client, error) {
+	client, err := XDial(p.rpcAddr, p.opt)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer p.dialed.Broadcast()
+	p.dialing--
+	if err != nil {
+		return nil, err
+	}
+	if p.closed {
+		_ = client.Close()
+		return nil, ErrPoolClosed
+	}
+	p.clients = append(p.clients, client)
+	return client, nil
+}
+
+// evictLocked 移出不可用的连接。
+// 服务端正在关闭时连接上还有等待响应的调用，这里不能立即关闭，先记录在 retired 中，
+// 调用都返回之后再关闭，Close 时一并关闭。
+func (p *Pool) evictLocked() {
+	clients := p.clients[:0]
+	for _, client := range p.clients {
+		if client.IsAvailable() {
+			clients = append(clients, client)
+		} else {
+			p.retired = append(p.retired, client)
+		}
+	}
+	for i := len(clients); i < len(p.clients); i++ {
+		p.clients[i] = nil
+	}
+	p.clients = clients
+
+	retired := p.retired[:0]
+	for _, client := range p.retired {
+		if client.NumPending() == 0 {
+			_ = client.Close()
+		} else {
+			retired = append(retired, client)
+		}
+	}
+	for i := len(retired); i < len(p.retired); i++ {
+		p.retired[i] = nil
+	}
+	p.retired = retired
+}
+
+func (p *Pool) leastPendingLocked() *Client {
+	var best *Client
+	min := 0
+	for _, client := range p.clients {
+		if n := client.NumPending(); best == nil || n < min {
+			best, min = client, n
+		}
+	}
+	return best
+}
+
+// Len returns the number of available connections in the pool.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evictLocked()
+	return len(p.clients)
+}
+
+// Close closes all connections in the pool.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.dialed.Broadcast()
+	for _, client := range append(p.clients, p.retired...) {
+		_ = client.Close()
+	}
+	p.clients, p.retired = nil, nil
+	return nil
+}
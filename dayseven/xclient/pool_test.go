@@ -0,0 +1,101 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "minirpc"
+	"testing"
+	"time"
+)
+
+// 所有连接都有调用在等待时建立新的连接，达到上限之后复用等待最少的连接。
+func TestPool_Get(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(1)
+	defer func() { _ = servers[0].Close() }()
+	pool := NewPool(addrs[0], 2, nil)
+	defer func() { _ = pool.Close() }()
+
+	c1, err := pool.Get()
+	_assert(err == nil, "failed to get a client: %v", err)
+	c2, _ := pool.Get()
+	_assert(c1 == c2 && pool.Len() == 1, "an idle client should be reused")
+
+	var r1, r2 int
+	slow := c1.Go("Foo.Sleep", 200, &r1, nil)
+	c2, _ = pool.Get()
+	_assert(c1 == c2, "the busy client should be returned while a new one is dialed in the background")
+	for pool.Len() < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c2, _ = pool.Get()
+	_assert(c1 != c2 && pool.Len() == 2, "expect the new client while c1 is busy")
+	c2.Go("Foo.Sleep", 200, &r2, nil)
+	c2.Go("Foo.Sleep", 200, &r2, nil)
+	c3, _ := pool.Get()
+	_assert(c3 == c1 && pool.Len() == 2, "expect the least pending client once the pool is full")
+	<-slow.Done
+
+	// 断开的连接被移出连接池
+	_ = c1.Close()
+	c3, err = pool.Get()
+	_assert(err == nil && c3 != c1, "a closed client shouldn't be returned: %v", err)
+	var reply int
+	err = c3.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, got %d (%v)", reply, err)
+
+	_ = pool.Close()
+	_, err = pool.Get()
+	_assert(err == ErrPoolClosed, "expect ErrPoolClosed, got %v", err)
+	_assert(!c3.IsAvailable(), "Close should close the clients in the pool")
+}
+
+// 服务端正在关闭时连接被移出连接池，Close 仍然会关闭它。
+func TestPool_retired(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(1)
+	defer func() { _ = servers[0].Close() }()
+	pool := NewPool(addrs[0], 2, nil)
+
+	c1, err := pool.Get()
+	_assert(err == nil, "failed to get a client: %v", err)
+	var reply int
+	slow := c1.Go("Foo.Sleep", 2000, &reply, nil)
+	time.Sleep(50 * time.Millisecond)
+	go func() { _ = servers[0].Shutdown(context.Background()) }()
+	for c1.IsAvailable() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(pool.Len() == 0, "a draining client should be evicted")
+	_ = pool.Close()
+	select {
+	case slow = <-slow.Done:
+		_assert(errors.Is(slow.Error, ErrShutdown), "expect ErrShutdown, got %v", slow.Error)
+	case <-time.After(time.Second):
+		t.Fatal("Close should close the evicted client")
+	}
+}
+
+// XClient 在并发调用时对同一个服务实例使用多个连接。
+func TestXClient_pool(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(1)
+	defer func() { _ = servers[0].Close() }()
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, nil)
+	xc.SetPoolSize(3)
+	defer func() { _ = xc.Close() }()
+
+	done := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		go func() {
+			var reply int
+			done <- xc.Call(context.Background(), "Foo.Sleep", 50, &reply)
+		}()
+	}
+	for i := 0; i < 6; i++ {
+		err := <-done
+		_assert(err == nil, "call failed: %v", err)
+	}
+	n := xc.pools[addrs[0]].Len()
+	_assert(n > 1 && n <= 3, "expect 2 to 3 connections, got %d", n)
+}
@@ -0,0 +1,190 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "minirpc"
+	"net"
+	"time"
+)
+
+// ErrAttemptTimeout is returned when a single attempt exceeds RetryPolicy.PerAttemptTimeout.
+var ErrAttemptTimeout = errors.New("rpc xclient: attempt timed out")
+
+// RetryPolicy controls how XClient.Call retries failed calls in Failover and Failtry mode.
+// Failover 每次重试都换一个还没有尝试过的服务实例，所有实例都尝试过之后再从头开始，Failtry 总是在同一个实例上重试；
+// 整个调用（包括等待退避的时间）不会超过 ctx 的截止时间。
+type RetryPolicy struct {
+	MaxAttempts       int                  // 包括第一次调用在内的最多尝试次数，不大于 1 时不重试
+	PerAttemptTimeout time.Duration        // 每次尝试的超时时间，为 0 时只受 ctx 限制
+	Backoff           Backoff              // 两次尝试之间的等待时间，为零值时使用 DefaultBackoff
+	Retryable         func(err error) bool // 判断错误是否可以重试，为 nil 时使用 IsRetryable
+}
+
+// IsRetryable reports whether err is a transient failure worth retrying:
+// a lost or refused connection, a server that is shutting down, or a timeout.
+func IsRetryable(err error) bool {
+	var ne net.Error
+	return errors.Is(err, ErrUnavailable) ||
+		errors.Is(err, ErrServerShuttingDown) ||
+		errors.Is(err, ErrHandleTimeout) ||
+		errors.Is(err, ErrAttemptTimeout) ||
+		errors.Is(err, ErrBreakerOpen) ||
+		errors.As(err, &ne)
+}
+
+type idempotentKey struct{}
+
+// WithIdempotent marks the call made with ctx as idempotent, so it can be retried
+// even if the server may already have handled it.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// SetRetryPolicy sets the retry policy used by Call, nil restores the default.
+// 没有设置重试策略时只重试没有发出的请求（例如连接失败、断路器打开）：Failover 依次换其他服务实例，
+// 每个实例最多尝试一次，服务端正在关闭时同样换一个实例；Failtry 在同一个实例上最多尝试 defaultFailtryAttempts 次。
+// Failfast 模式下不使用重试策略。
+func (xc *XClient) SetRetryPolicy(policy *RetryPolicy) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.retry = policy
+}
+
+// RegisterIdempotent marks the given "Service.Method"s as idempotent for every call.
+func (xc *XClient) RegisterIdempotent(serviceMethods ...string) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	for _, m := range serviceMethods {
+		xc.idempotent[m] = true
+	}
+}
+
+func (xc *XClient) isIdempotent(ctx context.Context, serviceMethod string) bool {
+	if v, _ := ctx.Value(idempotentKey{}).(bool); v {
+		return true
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	return xc.idempotent[serviceMethod]
+}
+
+// defaultFailtryAttempts 是没有设置重试策略时 Failtry 的最多尝试次数。
+const defaultFailtryAttempts = 3
+
+// retrier 记录一次 Call 的重试状态。
+type retrier struct {
+	policy      *RetryPolicy
+	mode        FailMode
+	idempotent  bool
+	maxAttempts int // 包括第一次调用在内的最多尝试次数
+	attempts    int
+	tried       map[string]bool // 已经尝试过的服务实例
+	last        string          // 上一次尝试的服务实例
+}
+
+func (xc *XClient) newRetrier(mode FailMode, policy *RetryPolicy, idempotent bool) *retrier {
+	r := &retrier{policy: policy, mode: mode, idempotent: idempotent, tried: make(map[string]bool)}
+	switch {
+	case policy != nil:
+		r.maxAttempts = policy.MaxAttempts
+	case r.mode == Failtry:
+		r.maxAttempts = defaultFailtryAttempts
+	case r.mode == Failover:
+		servers, _ := xc.d.GetAll()
+		r.maxAttempts = len(servers)
+	}
+	return r
+}
+
+// next 选择下一次尝试的服务实例：第一次按负载均衡策略选择，之后优先选择还没有尝试过的实例。
+func (r *retrier) next(xc *XClient) (string, error) {
+	if r.attempts == 0 {
+		return xc.get()
+	}
+	if r.mode == Failtry {
+		return r.last, nil
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range servers {
+		if !r.tried[addr] && xc.available(addr) {
+			return addr, nil
+		}
+	}
+	if r.policy == nil {
+		// 没有重试策略时每个实例只尝试一次
+		return "", errors.New("rpc xclient: no more servers to try")
+	}
+	r.tried = make(map[string]bool)
+	return xc.get()
+}
+
+// shouldRetry 判断第 r.attempts 次尝试失败之后是否继续重试。
+// sent 为 false 表示请求没有发出（例如连接失败），这样的调用总是可以安全地重试；
+// 请求已经发出时服务端可能已经处理过，只有幂等的调用才重试，服务端正在关闭时请求一定没有被处理。
+func (r *retrier) shouldRetry(err error, sent bool) bool {
+	if r.mode == Failfast || r.attempts >= r.maxAttempts {
+		return false
+	}
+	if r.policy == nil {
+		// 正在关闭的服务实例不会再处理请求，在同一个实例上重试没有意义
+		return !sent || r.mode == Failover && errors.Is(err, ErrServerShuttingDown)
+	}
+	retryable := r.policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(err) {
+		return false
+	}
+	return !sent || r.idempotent || errors.Is(err, ErrServerShuttingDown)
+}
+
+// wait 在两次尝试之间等待退避时间，ctx 先结束时返回 false。
+// 没有设置重试策略时，Failover 换一个服务实例立即重试，Failtry 按照 DefaultBackoff 等待。
+func (r *retrier) wait(ctx context.Context) bool {
+	var backoff Backoff
+	if r.policy != nil {
+		backoff = r.policy.Backoff
+	} else if r.mode != Failtry {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(backoff.Delay(r.attempts - 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// attempt 向 rpcAddr 发起一次调用，sent 表示请求是否已经发出。
+func (xc *XClient) attempt(rpcAddr string, ctx context.Context, policy *RetryPolicy, serviceMethod string, args, reply interface{}) (sent bool, err error) {
+	if !xc.allow(rpcAddr) {
+		return false, ErrBreakerOpen
+	}
+	client, err := xc.dial(rpcAddr)
+	if err != nil {
+		xc.report(rpcAddr, ctx, err)
+		return false, err
+	}
+	start := time.Now()
+	defer func() {
+		xc.observe(rpcAddr, start, err)
+		xc.report(rpcAddr, ctx, err)
+	}()
+	if policy == nil || policy.PerAttemptTimeout <= 0 {
+		return true, client.Call(ctx, serviceMethod, args, reply)
+	}
+	actx, cancel := context.WithTimeout(ctx, policy.PerAttemptTimeout)
+	defer cancel()
+	err = client.Call(actx, serviceMethod, args, reply)
+	if err != nil && ctx.Err() == nil && actx.Err() == context.DeadlineExceeded {
+		err = ErrAttemptTimeout
+	}
+	return true, err
+}
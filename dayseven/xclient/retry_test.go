@@ -0,0 +1,131 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	. "minirpc"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordAttempts 记录每个服务实例收到的调用，返回按到达顺序排列的服务实例编号。
+func recordAttempts(servers []*Server) func() []int {
+	var mu sync.Mutex
+	var got []int
+	for i, s := range servers {
+		i := i
+		s.Use(func(ctx context.Context, info *CallInfo, next Handler) error {
+			mu.Lock()
+			got = append(got, i)
+			mu.Unlock()
+			return next(ctx, info)
+		})
+	}
+	return func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), got...)
+	}
+}
+
+// 连接失败时请求没有发出，即使不是幂等的调用也会换一个服务实例重试。
+func TestXClient_retryDialError(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(1)
+	defer func() { _ = servers[0].Close() }()
+	l, _ := net.Listen("tcp", ":0")
+	dead := "tcp@" + l.Addr().String()
+	_ = l.Close()
+
+	xc := NewXClientWithMode(NewMultiServerDiscovery([]string{dead, addrs[0]}), RoundRobinSelect, Failover, nil)
+	defer func() { _ = xc.Close() }()
+	var reply int
+	for i := 0; i < 4; i++ {
+		err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "call %d failed without a retry policy: %v", i, err)
+	}
+
+	xc.SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, Backoff: Backoff{BaseDelay: time.Millisecond}})
+	for i := 0; i < 4; i++ {
+		err := xc.Call(context.Background(), "Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "call %d failed: %v", i, err)
+	}
+}
+
+// 请求已经发出之后超时，只有幂等的调用才重试，并且每次换一个服务实例。
+func TestXClient_retryIdempotent(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(3)
+	defer func() {
+		for _, s := range servers {
+			_ = s.Close()
+		}
+	}()
+	attempts := recordAttempts(servers)
+	xc := NewXClientWithMode(NewMultiServerDiscovery(addrs), RandomSelect, Failover, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetRetryPolicy(&RetryPolicy{
+		MaxAttempts:       3,
+		PerAttemptTimeout: 50 * time.Millisecond,
+		Backoff:           Backoff{BaseDelay: time.Millisecond},
+	})
+
+	var reply int
+	err := xc.Call(context.Background(), "Foo.Sleep", 200, &reply)
+	_assert(errors.Is(err, ErrAttemptTimeout), "expect ErrAttemptTimeout, got %v", err)
+	_assert(len(attempts()) == 1, "a non-idempotent call shouldn't be retried, got %d attempts", len(attempts()))
+
+	err = xc.Call(WithIdempotent(context.Background()), "Foo.Sleep", 200, &reply)
+	_assert(errors.Is(err, ErrAttemptTimeout), "expect ErrAttemptTimeout, got %v", err)
+	tried := attempts()[1:]
+	_assert(len(tried) == 3, "expect 3 attempts, got %d", len(tried))
+	_assert(tried[0] != tried[1] && tried[1] != tried[2] && tried[0] != tried[2], "each attempt should go to a different server: %v", tried)
+
+	xc.RegisterIdempotent("Foo.Sleep")
+	ctx, cancel := context.WithTimeout(context.Background(), 80*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = xc.Call(ctx, "Foo.Sleep", 200, &reply)
+	_assert(err != nil && time.Since(start) < 150*time.Millisecond, "the ctx deadline should bound all attempts: %v after %s", err, time.Since(start))
+	_assert(len(attempts()) == 6, "expect 2 attempts before the deadline, got %d", len(attempts())-4)
+}
+
+// 服务端在优雅关闭的过程中被强制关闭时，还没有返回的请求可能已经被处理过，不是幂等的调用不能重试。
+func TestXClient_retryForcedClose(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(2)
+	defer func() {
+		for _, s := range servers {
+			_ = s.Close()
+		}
+	}()
+	attempts := recordAttempts(servers)
+	xc := NewXClientWithMode(NewMultiServerDiscovery(addrs), RoundRobinSelect, Failover, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, Backoff: Backoff{BaseDelay: time.Millisecond}})
+
+	done := make(chan error, 1)
+	go func() {
+		var reply int
+		done <- xc.Call(context.Background(), "Foo.Sleep", 2000, &reply)
+	}()
+	for len(attempts()) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	server := servers[attempts()[0]]
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_assert(server.Shutdown(ctx) == context.DeadlineExceeded, "expect Shutdown to time out")
+	_ = server.Close()
+
+	select {
+	case err := <-done:
+		_assert(errors.Is(err, ErrUnavailable) && !errors.Is(err, ErrServerShuttingDown),
+			"expect ErrUnavailable, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("the call wasn't terminated by Close")
+	}
+	_assert(len(attempts()) == 1, "a non-idempotent call shouldn't be retried, got %d attempts", len(attempts()))
+}
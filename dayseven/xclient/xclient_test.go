@@ -0,0 +1,118 @@
+package xclient
+
+import (
+	"context"
+	"fmt"
+	. "minirpc"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (f Foo) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+type Inbox chan string
+
+func (in Inbox) Push(ctx context.Context, msg string) error {
+	in <- msg
+	return nil
+}
+
+func _assert(condition bool, msg string, v ...interface{}) {
+	if !condition {
+		panic(fmt.Sprintf("assertion failed:"+msg, v...))
+	}
+}
+
+// startServers 启动 n 个服务实例，返回 protocol@addr 形式的地址。
+func startServers(n int) ([]string, []*Server) {
+	addrs := make([]string, 0, n)
+	servers := make([]*Server, 0, n)
+	for i := 0; i < n; i++ {
+		server := NewServer()
+		var foo Foo
+		_ = server.Register(&foo)
+		l, err := net.Listen("tcp", ":0")
+		_assert(err == nil, "failed to listen: %v", err)
+		go server.Accept(l)
+		addrs = append(addrs, "tcp@"+l.Addr().String())
+		servers = append(servers, server)
+	}
+	return addrs, servers
+}
+
+// Option 中的拦截器作用于 XClient 发往每个服务实例的调用。
+func TestXClient_interceptors(t *testing.T) {
+	t.Parallel()
+	addrs, servers := startServers(3)
+	defer func() {
+		for _, s := range servers {
+			_ = s.Close()
+		}
+	}()
+	var mu sync.Mutex
+	calls := 0
+	opt := &Option{Interceptors: []ClientInterceptor{
+		func(ctx context.Context, serviceMethod string, args, reply interface{}, invoker Invoker) error {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+			return invoker(ctx, serviceMethod, args, reply)
+		},
+	}}
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RoundRobinSelect, opt)
+	defer func() { _ = xc.Close() }()
+
+	var reply int
+	err := xc.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, got %d (%v)", reply, err)
+	err = xc.Broadcast(context.Background(), "Foo.Sum", Args{Num1: 2, Num2: 2}, &reply)
+	_assert(err == nil && reply == 4, "expect 4, got %d (%v)", reply, err)
+	mu.Lock()
+	defer mu.Unlock()
+	_assert(calls == 4, "expect 4 intercepted calls, got %d", calls)
+}
+
+// BroadcastNotify 把单向调用发送给所有服务实例。
+func TestXClient_BroadcastNotify(t *testing.T) {
+	t.Parallel()
+	in := make(Inbox, 3)
+	addrs := make([]string, 0, 3)
+	for i := 0; i < 3; i++ {
+		server := NewServer()
+		_ = server.Register(in)
+		l, err := net.Listen("tcp", ":0")
+		_assert(err == nil, "failed to listen: %v", err)
+		go server.Accept(l)
+		defer func() { _ = server.Close() }()
+		addrs = append(addrs, "tcp@"+l.Addr().String())
+	}
+	xc := NewXClient(NewMultiServerDiscovery(addrs), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+
+	err := xc.BroadcastNotify("Inbox.Push", "invalidate")
+	_assert(err == nil, "failed to broadcast: %v", err)
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-in:
+			_assert(msg == "invalidate", "unexpected message %q", msg)
+		case <-time.After(time.Second):
+			t.Fatalf("only %d servers got the notification", i)
+		}
+	}
+	_assert(xc.Notify("Inbox.Push", "one") == nil && <-in == "one", "failed to notify")
+}